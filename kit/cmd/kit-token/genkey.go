@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"io"
+)
+
+const (
+	defaultKeyBits = 2048
+	checkIssuer    = "https://kit-token"
+	checkAudience  = "connect-kit-token"
+)
+
+func runGenKey(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("genkey", flag.ContinueOnError)
+	keyID := fs.String("id", "", "key ID, in the kN form")
+	bits := fs.Int("bits", defaultKeyBits, "RSA key size")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyID == "" {
+		return xerror.New(errorMissingArgument, "id")
+	}
+
+	privateKeyPEM, publicKeyPEM, err := generateKeyPair(*keyID, *bits)
+	if err != nil {
+		return err
+	}
+
+	privateKey := base64.StdEncoding.EncodeToString(privateKeyPEM)
+	publicKey := base64.StdEncoding.EncodeToString(publicKeyPEM)
+	fmt.Fprintf(stdout, "JWT_KEY_ID=%v\nJWT_KEY_PRIVATE=%v\nJWT_KEY_PUBLIC=%v\n", *keyID, privateKey, publicKey)
+	fmt.Fprintf(stdout, "\n# JWT_KEYS_PUBLIC entry:\n%v:%v\n", *keyID, publicKey)
+	return nil
+}
+
+// generateKeyPair generates a PEM encoded RSA keypair, and checks that it can issue and verify tokens.
+func generateKeyPair(keyID string, bits int) ([]byte, []byte, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+
+	tokenIssuer, err := utils.NewTokenIssuer(keyID, privateKeyPEM, checkIssuer, checkAudience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	if err != nil {
+		return nil, nil, err
+	}
+	tokenVerifier, err := utils.NewTokenVerifier(keyID, publicKeyPEM, checkIssuer, checkAudience)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := tokenIssuer.IssueAccessSystemToken()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, _, err := tokenVerifier.VerifyToken(token); err != nil {
+		return nil, nil, err
+	}
+
+	return privateKeyPEM, publicKeyPEM, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/ConnectCorp/go-kit/kit/server"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	errorInvalidTokenType = "invalid token type: %v"
+	errorInvalidClaims    = "invalid claims"
+	errorMissingArgument  = "missing argument: %v"
+)
+
+const (
+	tokenTypeAccess        = "access"
+	tokenTypeRefresh       = "refresh"
+	tokenTypeSystem        = "system"
+	tokenTypeService       = "service"
+	tokenTypeSinglePurpose = "single-purpose"
+)
+
+func runIssue(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("issue", flag.ContinueOnError)
+	envPrefix := fs.String("env-prefix", "", "prefix of the environment variables")
+	tokenType := fs.String("type", tokenTypeAccess, "token type: access, refresh, system, service, or single-purpose")
+	sub := fs.Int64("sub", 0, "subject")
+	actor := fs.Int64("actor", 0, "actor, for delegated access tokens")
+	scopes := fs.String("scope", "", "space-separated scopes, for access, system, and service tokens")
+	client := fs.String("client", "", "calling service, for service tokens")
+	target := fs.String("target", "", "target service, for service tokens")
+	role := fs.String("role", "", "role, for single-purpose tokens")
+	lifetime := fs.Duration("lifetime", utils.DefaultSinglePurposeTokenLifetime, "lifetime, for single-purpose tokens")
+	claims := fs.String("claims", "{}", "custom claims as a JSON object, for single-purpose tokens")
+	encrypted := fs.Bool("encrypted", false, "encrypt with JWT_ENCRYPTION_KEY_ID, for single-purpose tokens")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg := &server.TokenIssuerConfig{}
+	if err := envconfig.Process(*envPrefix, cfg); err != nil {
+		return err
+	}
+	tokenIssuer := server.MustInitTokenIssuer(cfg)
+
+	token, err := issueToken(tokenIssuer, *tokenType, *sub, *actor, strings.Fields(*scopes), *client, *target, *role, *lifetime, *claims, *encrypted)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, token)
+	return nil
+}
+
+func issueToken(tokenIssuer utils.TokenIssuer, tokenType string, sub, actor int64, scopes []string, client, target, role string, lifetime time.Duration, claims string, encrypted bool) (string, error) {
+	switch tokenType {
+	case tokenTypeAccess:
+		if actor != 0 {
+			return tokenIssuer.IssueDelegatedAccessUserToken(sub, actor, scopes...)
+		}
+		return tokenIssuer.IssueScopedAccessUserToken(sub, scopes...)
+	case tokenTypeRefresh:
+		return tokenIssuer.IssueRefreshUserToken(sub)
+	case tokenTypeSystem:
+		return tokenIssuer.IssueScopedAccessSystemToken(scopes...)
+	case tokenTypeService:
+		return tokenIssuer.IssueServiceToken(client, target, scopes...)
+	case tokenTypeSinglePurpose:
+		if role == "" {
+			return "", xerror.New(errorMissingArgument, "role")
+		}
+		customClaims, err := parseCustomClaims(claims)
+		if err != nil {
+			return "", err
+		}
+		descriptor := newDescriptorForClaims(role, sub != 0, lifetime, customClaims, encrypted)
+		return tokenIssuer.IssueSinglePurposeToken(descriptor, sub, customClaims)
+	default:
+		return "", xerror.New(errorInvalidTokenType, tokenType)
+	}
+}
+
+func parseCustomClaims(claims string) (map[string]interface{}, error) {
+	customClaims := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(claims)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&customClaims); err != nil {
+		return nil, xerror.Wrap(err, errorInvalidClaims)
+	}
+	return customClaims, nil
+}
+
+// newDescriptorForClaims makes a descriptor that allows exactly the given custom claims, as this tool has no knowledge
+// of the descriptors used by services.
+func newDescriptorForClaims(role string, isSubMeaningful bool, lifetime time.Duration, customClaims map[string]interface{}, encrypted bool) utils.SinglePurposeTokenDescriptor {
+	allowedCustomClaims := make(map[string]reflect.Type, len(customClaims))
+	for cN, cV := range customClaims {
+		allowedCustomClaims[cN] = reflect.TypeOf(cV)
+	}
+	options := make([]utils.SinglePurposeTokenDescriptorOption, 0, 1)
+	if encrypted {
+		options = append(options, utils.SinglePurposeTokenEncrypted())
+	}
+	return utils.NewSinglePurposeTokenDescriptor(role, isSubMeaningful, lifetime, allowedCustomClaims, options...)
+}
@@ -0,0 +1,70 @@
+// Command kit-token issues, verifies, and generates keys for Connect tokens.
+//
+// Tokens are issued and verified using the same environment variables as server.TokenIssuerConfig and
+// server.TokenVerifierConfig, optionally with a prefix (e.g. "-env-prefix AUTH" reads AUTH_JWT_ISSUER).
+//
+//	kit-token issue -type access -sub 1 -scope messages:read
+//	kit-token issue -type single-purpose -role password-reset -sub 1 -claims '{"email":"a@b.c"}'
+//	kit-token issue -type single-purpose -role invite -encrypted
+//	kit-token verify eyJhbGciOi...
+//	kit-token verify -role password-reset -claims '{"email":""}' eyJhbGciOi...
+//	kit-token genkey -id k2
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `usage: kit-token <command> [flags]
+
+commands:
+  issue    issue an access, refresh, system, service, or single-purpose token
+  verify   decode a token and verify it, reporting each check
+  genkey   generate a new RSA keypair for the JWT_KEY_* environment variables
+
+run "kit-token <command> -h" for the flags of each command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) (exitCode int) {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	// Config helpers from the server package panic on invalid values.
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(stderr, "error: %v\n", r)
+			exitCode = 1
+		}
+	}()
+
+	var err error
+	switch args[0] {
+	case "issue":
+		err = runIssue(args[1:], stdout)
+	case "verify":
+		err = runVerify(args[1:], stdout)
+	case "genkey":
+		err = runGenKey(args[1:], stdout)
+	default:
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	if err == flag.ErrHelp {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestKitToken(t *testing.T) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+	// The environment is restored once done.
+	previousEnv := map[string]*string{}
+	setenv := func(key, value string) {
+		if _, ok := previousEnv[key]; !ok {
+			if previous, ok := os.LookupEnv(key); ok {
+				previousEnv[key] = &previous
+			} else {
+				previousEnv[key] = nil
+			}
+		}
+		os.Setenv(key, value)
+	}
+	defer func() {
+		for key, previous := range previousEnv {
+			if previous != nil {
+				os.Setenv(key, *previous)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+	}()
+
+	// Generate a keypair and configure it.
+	assert.Equal(t, 0, run([]string{"genkey", "-id", "k7", "-bits", "1024"}, stdout, stderr))
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 {
+			setenv("KITTOKENTEST_"+kv[0], kv[1])
+		}
+	}
+	setenv("KITTOKENTEST_JWT_ISSUER", "https://test-issuer")
+	setenv("KITTOKENTEST_JWT_AUDIENCE", "connect-test")
+
+	issue := func(args ...string) string {
+		stdout.Reset()
+		assert.Equal(t, 0, run(append([]string{"issue", "-env-prefix", "KITTOKENTEST"}, args...), stdout, stderr), stderr.String())
+		return strings.TrimSpace(stdout.String())
+	}
+	verify := func(token string, args ...string) int {
+		stdout.Reset()
+		stderr.Reset()
+		return run(append(append([]string{"verify", "-env-prefix", "KITTOKENTEST"}, args...), token), stdout, stderr)
+	}
+
+	passwordResetArgs := []string{"-role", "password-reset", "-claims", `{"email":"","n":0}`}
+	for _, tokenAndArgs := range [][]string{
+		{issue("-type", "access", "-sub", "1", "-scope", "messages:read admin:users")},
+		{issue("-type", "access", "-sub", "1", "-actor", "2")},
+		{issue("-type", "refresh", "-sub", "1")},
+		{issue("-type", "system")},
+		append([]string{issue("-type", "single-purpose", "-role", "password-reset", "-sub", "1", "-claims", `{"email":"a@b.c","n":1}`)}, passwordResetArgs...),
+		{issue("-type", "single-purpose", "-role", "invite"), "-role", "invite", "-no-sub"},
+	} {
+		assert.Equal(t, 0, verify(tokenAndArgs[0], tokenAndArgs[1:]...), stderr.String())
+		assert.True(t, strings.HasSuffix(stdout.String(), "\nverified\n"), stdout.String())
+		assert.False(t, strings.Contains(stdout.String(), "FAIL"), stdout.String())
+	}
+
+	// Single purpose tokens are verified against the expected role and custom claims.
+	passwordResetToken := issue("-type", "single-purpose", "-role", "password-reset", "-sub", "1", "-claims", `{"email":"a@b.c","n":1}`)
+	assert.Equal(t, 1, verify(passwordResetToken, "-role", "password-reset", "-claims", `{"email":"","n":0,"code":""}`))
+	assert.Equal(t, "error: verification failed: invalid token: missing custom claims: map[code:true]\n", stderr.String())
+	assert.Equal(t, 1, verify(passwordResetToken, "-role", "invite"))
+	assert.Equal(t, "error: verification failed: invalid token\n", stderr.String())
+	assert.Equal(t, 1, verify(passwordResetToken))
+	assert.Equal(t, "error: verification failed: missing argument: role\n", stderr.String())
+
+	// Token formats are checked against the accepted ones.
+	assert.Equal(t, 1, verify(issue("-type", "system"), "-formats", "v2"))
+	assert.Contains(t, stdout.String(), `FAIL v    unsupported version "v1"`)
+	assert.Equal(t, 1, verify(issue("-type", "system"), "-formats", "v3"))
+	assert.Equal(t, "error: invalid token format: v3\n", stderr.String())
+
+	// Tokens for another audience are reported as such.
+	serviceToken := issue("-type", "service", "-client", "messages", "-target", "notifications")
+	assert.Equal(t, 1, verify(serviceToken))
+	assert.Contains(t, stdout.String(), `FAIL aud  got ["notifications"], expected one of ["connect-test"]`)
+	assert.Equal(t, "error: verification failed: invalid token\n", stderr.String())
+
+	setenv("KITTOKENTEST_JWT_SERVICE", "notifications")
+	assert.Equal(t, 0, verify(serviceToken), stderr.String())
+	os.Unsetenv("KITTOKENTEST_JWT_SERVICE")
+
+	// Encrypted tokens are decrypted with the configured keys.
+	setenv("KITTOKENTEST_JWT_ENCRYPTION_KEYS", "e1:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=")
+	setenv("KITTOKENTEST_JWT_ENCRYPTION_KEY_ID", "e1")
+	encryptedToken := issue("-type", "single-purpose", "-role", "password-reset", "-sub", "1", "-claims", `{"email":"a@b.c"}`, "-encrypted")
+	assert.Equal(t, 5, len(strings.Split(encryptedToken, ".")))
+	assert.Equal(t, 0, verify(encryptedToken, "-role", "password-reset", "-claims", `{"email":""}`), stderr.String())
+	assert.Contains(t, stdout.String(), `"email": "a@b.c"`)
+	assert.True(t, strings.HasSuffix(stdout.String(), "\nverified\n"), stdout.String())
+	setenv("KITTOKENTEST_JWT_ENCRYPTION_KEYS", "e1:AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=")
+	assert.Equal(t, 1, verify(encryptedToken, "-role", "password-reset", "-claims", `{"email":""}`))
+	assert.True(t, strings.HasPrefix(stderr.String(), "error: unable to decrypt token: invalid token"), stderr.String())
+
+	assert.Equal(t, 1, verify("bad"))
+	assert.Equal(t, "error: malformed token\n", stderr.String())
+
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"issue", "-env-prefix", "KITTOKENTEST", "-type", "other"}, stdout, stderr))
+	assert.Equal(t, "error: invalid token type: other\n", stderr.String())
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"genkey", "-id", "bad"}, stdout, stderr))
+	assert.Equal(t, "error: invalid key ID: bad\n", stderr.String())
+	assert.Equal(t, 2, run([]string{"other"}, stdout, stderr))
+	assert.Equal(t, 2, run(nil, stdout, stderr))
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/ConnectCorp/go-kit/kit/server"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/kelseyhightower/envconfig"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	errorMalformedToken     = "malformed token"
+	errorUnableToDecrypt    = "unable to decrypt token"
+	errorVerificationFailed = "verification failed"
+	errorInvalidTokenFormat = "invalid token format: %v"
+)
+
+// decodedToken is a token decoded without verification, for reporting purposes.
+type decodedToken struct {
+	header map[string]interface{}
+	claims map[string]interface{}
+}
+
+// tokenCheck is the outcome of a single check on a decoded token.
+type tokenCheck struct {
+	name   string
+	passed bool
+	detail string
+}
+
+func runVerify(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	envPrefix := fs.String("env-prefix", "", "prefix of the environment variables")
+	formats := fs.String("formats", "v1 v2", "space-separated accepted token format versions")
+	role := fs.String("role", "", "expected role, for single-purpose tokens")
+	noSub := fs.Bool("no-sub", false, "expect no subject, for single-purpose tokens")
+	claims := fs.String("claims", "{}", "allowed custom claims as a JSON object of example values, for single-purpose tokens")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return xerror.New(errorMissingArgument, "token")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(fs.Arg(0), "Bearer"))
+
+	tokenFormats, err := parseTokenFormats(*formats)
+	if err != nil {
+		return err
+	}
+	var descriptor utils.SinglePurposeTokenDescriptor
+	if *role != "" {
+		customClaims, err := parseCustomClaims(*claims)
+		if err != nil {
+			return err
+		}
+		descriptor = newDescriptorForClaims(*role, !*noSub, 0, customClaims, utils.IsEncryptedToken(token))
+	}
+
+	cfg := &server.TokenVerifierConfig{}
+	if err := envconfig.Process(*envPrefix, cfg); err != nil {
+		return err
+	}
+	tokenVerifier := server.MustInitTokenVerifier(cfg, utils.TokenVerifierFormats(tokenFormats...))
+
+	return verifyToken(tokenVerifier, cfg, tokenFormats, descriptor, time.Now(), token, stdout)
+}
+
+// verifyToken reports the claims of the token and verifies it. Single purpose tokens are verified against the given
+// descriptor, which is nil for authentication tokens. Encrypted tokens are decrypted with JWT_ENCRYPTION_KEYS.
+func verifyToken(tokenVerifier utils.TokenVerifier, cfg *server.TokenVerifierConfig, formats []utils.TokenFormat, descriptor utils.SinglePurposeTokenDescriptor, now time.Time, token string, stdout io.Writer) error {
+	signedToken := token
+	if utils.IsEncryptedToken(token) {
+		decrypted, err := utils.DecryptToken(cfg.JWTEncryptionKeys, token)
+		if err != nil {
+			return xerror.Wrap(err, errorUnableToDecrypt)
+		}
+		signedToken = decrypted
+	}
+	dt, err := decodeToken(signedToken)
+	if err != nil {
+		return err
+	}
+
+	header, _ := json.MarshalIndent(dt.header, "", "  ")
+	claims, _ := json.MarshalIndent(dt.claims, "", "  ")
+	fmt.Fprintf(stdout, "header: %s\nclaims: %s\n\n", header, claims)
+
+	for _, check := range checkToken(dt, cfg, formats, now) {
+		status := "ok  "
+		if !check.passed {
+			status = "FAIL"
+		}
+		fmt.Fprintf(stdout, "%s %-4s %s\n", status, check.name, check.detail)
+	}
+
+	if err := verifyDecodedToken(tokenVerifier, descriptor, dt, token); err != nil {
+		return xerror.Wrap(err, errorVerificationFailed)
+	}
+	fmt.Fprintln(stdout, "\nverified")
+	return nil
+}
+
+func verifyDecodedToken(tokenVerifier utils.TokenVerifier, descriptor utils.SinglePurposeTokenDescriptor, dt *decodedToken, token string) error {
+	if descriptor != nil {
+		_, _, err := tokenVerifier.VerifySinglePurposeTokenClaims(token, descriptor)
+		return err
+	}
+	switch dt.claims["role"] {
+	case utils.TokenAccessUserRole, utils.TokenRefreshUserRole, utils.TokenAccessSystemRole:
+		_, err := tokenVerifier.VerifyTokenClaims(token)
+		return err
+	default:
+		return xerror.New(errorMissingArgument, "role")
+	}
+}
+
+func parseTokenFormats(formats string) ([]utils.TokenFormat, error) {
+	tokenFormats := make([]utils.TokenFormat, 0, len(utils.DefaultTokenFormats))
+	for _, version := range strings.Fields(formats) {
+		found := false
+		for _, format := range utils.DefaultTokenFormats {
+			if format.GetVersion() == version {
+				tokenFormats = append(tokenFormats, format)
+				found = true
+			}
+		}
+		if !found {
+			return nil, xerror.New(errorInvalidTokenFormat, version)
+		}
+	}
+	return tokenFormats, nil
+}
+
+func decodeToken(token string) (*decodedToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, xerror.New(errorMalformedToken)
+	}
+	dt := &decodedToken{}
+	for i, v := range []*map[string]interface{}{&dt.header, &dt.claims} {
+		segment, err := jwt.DecodeSegment(parts[i])
+		if err != nil {
+			return nil, xerror.Wrap(err, errorMalformedToken)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(segment))
+		decoder.UseNumber()
+		if err := decoder.Decode(v); err != nil {
+			return nil, xerror.Wrap(err, errorMalformedToken)
+		}
+	}
+	return dt, nil
+}
+
+// checkToken explains why a token would be rejected, since verification errors are deliberately terse.
+func checkToken(dt *decodedToken, cfg *server.TokenVerifierConfig, formats []utils.TokenFormat, now time.Time) []*tokenCheck {
+	checks := make([]*tokenCheck, 0, 7)
+
+	kid, _ := dt.header["kid"].(string)
+	kidCheck := &tokenCheck{name: "kid", detail: fmt.Sprintf("unknown key ID %q", kid)}
+	for _, key := range server.MustGetTokenVerificationKeys(cfg) {
+		if key.KeyID == kid {
+			kidCheck.passed = !key.IsRetired(now)
+			kidCheck.detail = fmt.Sprintf("key ID %q, retires at %v", kid, key.RetiresAt)
+		}
+	}
+	checks = append(checks, kidCheck)
+
+	v, _ := dt.claims["v"].(string)
+	vCheck := &tokenCheck{name: "v", detail: fmt.Sprintf("unsupported version %q", v)}
+	for _, format := range formats {
+		if format.GetVersion() == v {
+			vCheck.passed = true
+			vCheck.detail = fmt.Sprintf("version %q", v)
+		}
+	}
+	checks = append(checks, vCheck)
+
+	iss, _ := dt.claims["iss"].(string)
+	checks = append(checks, &tokenCheck{
+		name:   "iss",
+		passed: iss == cfg.JWTIssuer,
+		detail: fmt.Sprintf("got %q, expected %q", iss, cfg.JWTIssuer),
+	})
+
+	aud := getAudience(dt.claims["aud"])
+	expectedAud := []string{cfg.JWTAudience}
+	if cfg.JWTService != "" {
+		expectedAud = append(expectedAud, cfg.JWTService)
+	}
+	audCheck := &tokenCheck{name: "aud", detail: fmt.Sprintf("got %q, expected one of %q", aud, expectedAud)}
+	for _, a := range expectedAud {
+		audCheck.passed = audCheck.passed || utils.StringSliceContains(aud, a)
+	}
+	checks = append(checks, audCheck)
+
+	checks = append(checks, checkTime("exp", dt.claims["exp"], func(t time.Time) bool {
+		return !now.After(t.Add(cfg.JWTLeeway))
+	}))
+	if _, ok := dt.claims["nbf"]; ok {
+		checks = append(checks, checkTime("nbf", dt.claims["nbf"], func(t time.Time) bool {
+			return !now.Before(t.Add(-cfg.JWTLeeway))
+		}))
+	}
+	checks = append(checks, checkTime("iat", dt.claims["iat"], func(time.Time) bool {
+		return true
+	}))
+
+	return checks
+}
+
+func checkTime(name string, claim interface{}, isValid func(time.Time) bool) *tokenCheck {
+	n, ok := claim.(json.Number)
+	if !ok {
+		return &tokenCheck{name: name, detail: "missing or not a number"}
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return &tokenCheck{name: name, detail: "not an integer"}
+	}
+	t := time.Unix(i, 0)
+	return &tokenCheck{name: name, passed: isValid(t), detail: t.UTC().Format(time.RFC3339)}
+}
+
+func getAudience(claim interface{}) []string {
+	switch aud := claim.(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		auds := make([]string, 0, len(aud))
+		for _, a := range aud {
+			auds = append(auds, fmt.Sprintf("%v", a))
+		}
+		return auds
+	default:
+		return nil
+	}
+}
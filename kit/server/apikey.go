@@ -0,0 +1,108 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/guregu/null"
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"strings"
+	"time"
+)
+
+const (
+	errorUnableToStoreAPIKey  = "unable to store api key"
+	errorUnableToGetAPIKey    = "unable to get api key"
+	errorUnableToRevokeAPIKey = "unable to revoke api key"
+	defaultAPIKeyTable        = "api_keys"
+)
+
+// sqlAPIKey is the row representation of a utils.APIKey.
+type sqlAPIKey struct {
+	ID        string    `db:"id"`
+	Hash      string    `db:"hash"`
+	Owner     string    `db:"owner"`
+	Scopes    string    `db:"scopes"`
+	CreatedAt time.Time `db:"created_at"`
+	RevokedAt null.Time `db:"revoked_at"`
+}
+
+// SQLAPIKeyStore is a utils.APIKeyStore backed by a SQL table, e.g. for MySQL (with parseTime=true):
+//
+//	CREATE TABLE `api_keys` (
+//	  `id` CHAR(16) NOT NULL PRIMARY KEY,
+//	  `hash` CHAR(64) NOT NULL,
+//	  `owner` VARCHAR(255) NOT NULL,
+//	  `scopes` TEXT NOT NULL,
+//	  `created_at` DATETIME NOT NULL,
+//	  `revoked_at` DATETIME NULL
+//	);
+type SQLAPIKeyStore struct {
+	db    *sqlx.DB
+	table string
+}
+
+// NewSQLAPIKeyStore initializes a new SQLAPIKeyStore using the given table.
+func NewSQLAPIKeyStore(db *sqlx.DB, table string) *SQLAPIKeyStore {
+	return &SQLAPIKeyStore{
+		db:    db,
+		table: table,
+	}
+}
+
+// InitSQLAPIKeyStore initializes a new SQLAPIKeyStore with default settings.
+func InitSQLAPIKeyStore(db *sqlx.DB) *SQLAPIKeyStore {
+	return NewSQLAPIKeyStore(db, defaultAPIKeyTable)
+}
+
+// StoreAPIKey implements the utils.APIKeyStore interface.
+func (s *SQLAPIKeyStore) StoreAPIKey(apiKey *utils.APIKey) error {
+	row := &sqlAPIKey{
+		ID:        apiKey.ID,
+		Hash:      apiKey.Hash,
+		Owner:     apiKey.Owner,
+		Scopes:    strings.Join(apiKey.Scopes, " "),
+		CreatedAt: apiKey.CreatedAt.UTC(),
+		RevokedAt: null.NewTime(apiKey.RevokedAt.UTC(), apiKey.IsRevoked()),
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO `%v` (`id`, `hash`, `owner`, `scopes`, `created_at`, `revoked_at`) VALUES (:id, :hash, :owner, :scopes, :created_at, :revoked_at)",
+		s.table)
+	if _, err := s.db.NamedExec(query, row); err != nil {
+		return xerror.Wrap(err, errorUnableToStoreAPIKey, apiKey.ID)
+	}
+	return nil
+}
+
+// GetAPIKey implements the utils.APIKeyStore interface.
+func (s *SQLAPIKeyStore) GetAPIKey(id string) (*utils.APIKey, error) {
+	row := &sqlAPIKey{}
+	query := fmt.Sprintf("SELECT `id`, `hash`, `owner`, `scopes`, `created_at`, `revoked_at` FROM `%v` WHERE `id` = ?", s.table)
+	if err := s.db.Get(row, s.db.Rebind(query), id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, xerror.Wrap(err, errorUnableToGetAPIKey, id)
+	}
+	apiKey := &utils.APIKey{
+		ID:        row.ID,
+		Hash:      row.Hash,
+		Owner:     row.Owner,
+		Scopes:    strings.Fields(row.Scopes),
+		CreatedAt: row.CreatedAt,
+	}
+	if row.RevokedAt.Valid {
+		apiKey.RevokedAt = row.RevokedAt.Time
+	}
+	return apiKey, nil
+}
+
+// RevokeAPIKey implements the utils.APIKeyStore interface.
+func (s *SQLAPIKeyStore) RevokeAPIKey(id string) error {
+	query := fmt.Sprintf("UPDATE `%v` SET `revoked_at` = ? WHERE `id` = ? AND `revoked_at` IS NULL", s.table)
+	if _, err := s.db.Exec(s.db.Rebind(query), time.Now().UTC(), id); err != nil {
+		return xerror.Wrap(err, errorUnableToRevokeAPIKey, id)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/ConnectCorp/go-kit/kit/service"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	kitlog "github.com/go-kit/kit/log"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// ErrorInvalidAuditSink is used when initializing an audit sink of unknown type or with missing settings.
+	ErrorInvalidAuditSink = "invalid audit sink: %v"
+
+	errorUnableToWriteAuditEvent = "unable to write audit event"
+	errorAuditSinkFull           = "audit sink full"
+	errorAuditSinkClosed         = "audit sink closed"
+	auditSinkLog                 = "log"
+	auditSinkFile                = "file"
+	auditSinkKinesis             = "kinesis"
+)
+
+const (
+	// DefaultKinesisAuditBufferSize is the default number of events buffered by a KinesisAuditSink.
+	DefaultKinesisAuditBufferSize = 10000
+
+	kinesisMaxRecordsPerRequest = 500 // PutRecords limit.
+)
+
+// KinesisAuditSink is a service.AuditSink that puts events to a Kinesis stream as JSON records, partitioned by trace ID.
+// Events are buffered and put in batches by a background goroutine, so that requests do not wait for Kinesis. Events
+// that do not fit in the buffer, or that Kinesis fails to put, are dropped and counted.
+type KinesisAuditSink struct {
+	droppedEvents uint64 // First, to be 64-bit aligned for atomic operations.
+	client        kinesisiface.KinesisAPI
+	stream        string
+	entries       chan *kinesis.PutRecordsRequestEntry
+	done          chan struct{}
+	mutex         *sync.RWMutex
+	isClosed      bool
+}
+
+// NewKinesisAuditSink initializes a new KinesisAuditSink buffering up to bufferSize events, and starts putting them.
+func NewKinesisAuditSink(client kinesisiface.KinesisAPI, stream string, bufferSize int) *KinesisAuditSink {
+	s := &KinesisAuditSink{
+		client:  client,
+		stream:  stream,
+		entries: make(chan *kinesis.PutRecordsRequestEntry, bufferSize),
+		done:    make(chan struct{}),
+		mutex:   &sync.RWMutex{},
+	}
+	go s.run()
+	return s
+}
+
+// WriteAuditEvent implements the service.AuditSink interface. It does not wait for the event to be put.
+func (s *KinesisAuditSink) WriteAuditEvent(event *service.AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return xerror.Wrap(err, errorUnableToWriteAuditEvent)
+	}
+	partitionKey := event.TraceID
+	if partitionKey == "" {
+		partitionKey = event.Route
+	}
+	entry := &kinesis.PutRecordsRequestEntry{
+		PartitionKey: aws.String(partitionKey),
+		Data:         data,
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.isClosed {
+		s.drop(1)
+		return xerror.Wrap(xerror.New(errorAuditSinkClosed), errorUnableToWriteAuditEvent, s.stream)
+	}
+	select {
+	case s.entries <- entry:
+		return nil
+	default:
+		s.drop(1)
+		return xerror.Wrap(xerror.New(errorAuditSinkFull), errorUnableToWriteAuditEvent, s.stream)
+	}
+}
+
+// GetDroppedEvents returns the number of events dropped so far.
+func (s *KinesisAuditSink) GetDroppedEvents() uint64 {
+	return atomic.LoadUint64(&s.droppedEvents)
+}
+
+// Close stops accepting events, and waits for the buffered ones to be put.
+func (s *KinesisAuditSink) Close() {
+	s.mutex.Lock()
+	if !s.isClosed {
+		s.isClosed = true
+		close(s.entries)
+	}
+	s.mutex.Unlock()
+	<-s.done
+}
+
+func (s *KinesisAuditSink) run() {
+	defer close(s.done)
+	for entry := range s.entries {
+		entries := []*kinesis.PutRecordsRequestEntry{entry}
+	batch:
+		for len(entries) < kinesisMaxRecordsPerRequest {
+			select {
+			case entry, ok := <-s.entries:
+				if !ok {
+					break batch
+				}
+				entries = append(entries, entry)
+			default:
+				break batch
+			}
+		}
+		s.putRecords(entries)
+	}
+}
+
+func (s *KinesisAuditSink) putRecords(entries []*kinesis.PutRecordsRequestEntry) {
+	output, err := s.client.PutRecords(&kinesis.PutRecordsInput{
+		StreamName: aws.String(s.stream),
+		Records:    entries,
+	})
+	if err != nil {
+		s.drop(len(entries))
+		return
+	}
+	if output.FailedRecordCount != nil {
+		s.drop(int(*output.FailedRecordCount))
+	}
+}
+
+func (s *KinesisAuditSink) drop(n int) {
+	atomic.AddUint64(&s.droppedEvents, uint64(n))
+}
+
+// MustInitAuditSink initializes a new service.AuditSink from config, or panics.
+func MustInitAuditSink(commonCfg *CommonConfig, cfg *AuditConfig, rootLogger kitlog.Logger) service.AuditSink {
+	switch cfg.AuditSink {
+	case auditSinkLog:
+		return service.NewLoggerAuditSink(rootLogger)
+	case auditSinkFile:
+		if cfg.AuditFile == "" {
+			panic(xerror.New(ErrorInvalidAuditSink, cfg.AuditSink))
+		}
+		f, err := os.OpenFile(cfg.AuditFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			panic(xerror.Wrap(err, ErrorInvalidAuditSink, cfg.AuditSink))
+		}
+		return service.NewWriterAuditSink(f)
+	case auditSinkKinesis:
+		if cfg.AuditKinesisStream == "" {
+			panic(xerror.New(ErrorInvalidAuditSink, cfg.AuditSink))
+		}
+		return NewKinesisAuditSink(InitAWSKinesis(commonCfg), cfg.AuditKinesisStream, DefaultKinesisAuditBufferSize)
+	default:
+		panic(xerror.New(ErrorInvalidAuditSink, cfg.AuditSink))
+	}
+}
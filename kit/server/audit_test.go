@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/ConnectCorp/go-kit/kit/service"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testKinesisClient struct {
+	kinesisiface.KinesisAPI
+	inputs  []*kinesis.PutRecordsInput
+	err     error
+	failed  int64
+	started chan bool
+	release chan bool
+}
+
+func (c *testKinesisClient) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	if c.started != nil {
+		c.started <- true
+		<-c.release
+	}
+	c.inputs = append(c.inputs, input)
+	return &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(c.failed)}, c.err
+}
+
+func (c *testKinesisClient) getRecords() []*kinesis.PutRecordsRequestEntry {
+	records := make([]*kinesis.PutRecordsRequestEntry, 0)
+	for _, input := range c.inputs {
+		records = append(records, input.Records...)
+	}
+	return records
+}
+
+func TestKinesisAuditSink(t *testing.T) {
+	client := &testKinesisClient{}
+	sink := NewKinesisAuditSink(client, "audit", DefaultKinesisAuditBufferSize)
+
+	assert.Nil(t, sink.WriteAuditEvent(&service.AuditEvent{Decision: service.AuditDecisionAllow, Route: "GET /messages", TraceID: "trace"}))
+	assert.Nil(t, sink.WriteAuditEvent(&service.AuditEvent{Decision: service.AuditDecisionDeny, Route: "GET /messages"}))
+	sink.Close()
+	records := client.getRecords()
+	assert.Len(t, records, 2)
+	assert.Equal(t, "audit", *client.inputs[0].StreamName)
+	assert.Equal(t, "trace", *records[0].PartitionKey)
+	assert.Equal(t, "GET /messages", *records[1].PartitionKey)
+	event := &service.AuditEvent{}
+	assert.Nil(t, json.Unmarshal(records[0].Data, event))
+	assert.Equal(t, service.AuditDecisionAllow, event.Decision)
+	assert.Equal(t, uint64(0), sink.GetDroppedEvents())
+
+	err := sink.WriteAuditEvent(&service.AuditEvent{Decision: service.AuditDecisionAllow, Route: "GET /messages"})
+	assert.Equal(t, "unable to write audit event: audit sink closed", err.Error())
+	assert.Equal(t, uint64(1), sink.GetDroppedEvents())
+	sink.Close()
+}
+
+func TestKinesisAuditSinkDrops(t *testing.T) {
+	// Events that do not fit in the buffer are dropped without waiting.
+	client := &testKinesisClient{started: make(chan bool), release: make(chan bool)}
+	sink := NewKinesisAuditSink(client, "audit", 1)
+	event := &service.AuditEvent{Decision: service.AuditDecisionAllow, Route: "GET /messages"}
+	assert.Nil(t, sink.WriteAuditEvent(event))
+	<-client.started
+	assert.Nil(t, sink.WriteAuditEvent(event))
+	err := sink.WriteAuditEvent(event)
+	assert.Equal(t, "unable to write audit event: audit sink full", err.Error())
+	assert.Equal(t, uint64(1), sink.GetDroppedEvents())
+	client.release <- true
+	<-client.started
+	client.release <- true
+	sink.Close()
+	assert.Len(t, client.getRecords(), 2)
+	assert.Equal(t, uint64(1), sink.GetDroppedEvents())
+
+	// Events that Kinesis fails to put are dropped.
+	client = &testKinesisClient{err: xerror.New("broken")}
+	sink = NewKinesisAuditSink(client, "audit", DefaultKinesisAuditBufferSize)
+	assert.Nil(t, sink.WriteAuditEvent(event))
+	sink.Close()
+	assert.Equal(t, uint64(1), sink.GetDroppedEvents())
+	client = &testKinesisClient{failed: 1}
+	sink = NewKinesisAuditSink(client, "audit", DefaultKinesisAuditBufferSize)
+	assert.Nil(t, sink.WriteAuditEvent(event))
+	sink.Close()
+	assert.Equal(t, uint64(1), sink.GetDroppedEvents())
+}
+
+func TestMustInitAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink := MustInitAuditSink(&CommonConfig{}, &AuditConfig{AuditSink: "file", AuditFile: path}, service.NewRootLogger(os.Stdout))
+	assert.Nil(t, sink.WriteAuditEvent(&service.AuditEvent{Decision: service.AuditDecisionAllow, Route: "GET /messages"}))
+	buf, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(buf), `"decision":"allow"`)
+
+	assert.IsType(t, &service.LoggerAuditSink{}, MustInitAuditSink(&CommonConfig{}, &AuditConfig{AuditSink: "log"}, service.NewRootLogger(os.Stdout)))
+	assert.IsType(t, &KinesisAuditSink{}, MustInitAuditSink(&CommonConfig{}, &AuditConfig{AuditSink: "kinesis", AuditKinesisStream: "audit"}, nil))
+	assert.Panics(t, func() { MustInitAuditSink(&CommonConfig{}, &AuditConfig{AuditSink: "file"}, nil) })
+	assert.Panics(t, func() { MustInitAuditSink(&CommonConfig{}, &AuditConfig{AuditSink: "kinesis"}, nil) })
+	assert.Panics(t, func() { MustInitAuditSink(&CommonConfig{}, &AuditConfig{AuditSink: "other"}, nil) })
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/ConnectCorp/go-kit/kit/utils"
+	"time"
 )
 
 // CommonConfig contains common configuration keys for Connect microservices.
@@ -11,17 +12,57 @@ type CommonConfig struct {
 }
 
 // TokenVerifierConfig contains configuration keys for services that verify tokens.
+// At least one of JWT_KEY_ID/JWT_KEY_PUBLIC or JWT_KEYS_PUBLIC must be provided.
+// JWT_SERVICE names this service, to accept service tokens issued for it. JWT_SERVICE_ONLY rejects other system tokens.
+// JWT_ENCRYPTION_KEYS contains the 256 bit keys for encrypted single purpose tokens, formatted as for JWT_KEYS_PUBLIC.
 type TokenVerifierConfig struct {
-	JWTKeyID     string          `envconfig:"JWT_KEY_ID" required:"true"`
-	JWTKeyPublic utils.EnvBinary `envconfig:"JWT_KEY_PUBLIC" required:"true"`
-	JWTIssuer    string          `envconfig:"JWT_ISSUER" required:"true"`
-	JWTAudience  string          `envconfig:"JWT_AUDIENCE" required:"true"`
+	JWTKeyID          string             `envconfig:"JWT_KEY_ID"`
+	JWTKeyPublic      utils.EnvBinary    `envconfig:"JWT_KEY_PUBLIC"`
+	JWTKeysPublic     utils.EnvBinaryMap `envconfig:"JWT_KEYS_PUBLIC"`
+	JWTKeysRetireAt   utils.EnvTimeMap   `envconfig:"JWT_KEYS_RETIRE_AT"`
+	JWTIssuer         string             `envconfig:"JWT_ISSUER" required:"true"`
+	JWTAudience       string             `envconfig:"JWT_AUDIENCE" required:"true"`
+	JWTLeeway         time.Duration      `envconfig:"JWT_LEEWAY"`
+	JWTService        string             `envconfig:"JWT_SERVICE"`
+	JWTServiceOnly    bool               `envconfig:"JWT_SERVICE_ONLY"`
+	JWTEncryptionKeys utils.EnvBinaryMap `envconfig:"JWT_ENCRYPTION_KEYS"`
+}
+
+// RemoteTokenVerifierConfig contains configuration keys for services that verify tokens using keys fetched from a JWKS URL.
+type RemoteTokenVerifierConfig struct {
+	JWTJWKSURL        utils.EnvURL       `envconfig:"JWT_JWKS_URL" required:"true"`
+	JWTIssuer         string             `envconfig:"JWT_ISSUER" required:"true"`
+	JWTAudience       string             `envconfig:"JWT_AUDIENCE" required:"true"`
+	JWTLeeway         time.Duration      `envconfig:"JWT_LEEWAY"`
+	JWTService        string             `envconfig:"JWT_SERVICE"`
+	JWTServiceOnly    bool               `envconfig:"JWT_SERVICE_ONLY"`
+	JWTEncryptionKeys utils.EnvBinaryMap `envconfig:"JWT_ENCRYPTION_KEYS"`
 }
 
 // TokenIssuerConfig contains configuration keys for services that issue tokens.
+// JWT_ENCRYPTION_KEY_ID selects the key from JWT_ENCRYPTION_KEYS used for encrypting single purpose tokens.
 type TokenIssuerConfig struct {
 	TokenVerifierConfig
-	JWTKeyPrivate utils.EnvBinary `envconfig:"JWT_KEY_PRIVATE" required:"true"`
+	JWTKeyPrivate      utils.EnvBinary `envconfig:"JWT_KEY_PRIVATE" required:"true"`
+	JWTEncryptionKeyID string          `envconfig:"JWT_ENCRYPTION_KEY_ID"`
+}
+
+// ServerTLSConfig contains configuration keys for services that serve TLS. TLS_CERT and TLS_KEY contain the PEM encoded
+// server certificate chain and private key. TLS_CLIENT_CA contains the PEM encoded CA certificates used to verify client
+// certificates; TLS_CLIENT_CERT_REQUIRED rejects connections that do not present one.
+type ServerTLSConfig struct {
+	TLSCert               utils.EnvBinary `envconfig:"TLS_CERT" required:"true"`
+	TLSKey                utils.EnvBinary `envconfig:"TLS_KEY" required:"true"`
+	TLSClientCA           utils.EnvBinary `envconfig:"TLS_CLIENT_CA"`
+	TLSClientCertRequired bool            `envconfig:"TLS_CLIENT_CERT_REQUIRED"`
+}
+
+// AuditConfig contains configuration keys for services that record authorization decisions. AUDIT_SINK is one of
+// "log", "file" (appending to AUDIT_FILE), or "kinesis" (putting records to AUDIT_KINESIS_STREAM).
+type AuditConfig struct {
+	AuditSink          string `envconfig:"AUDIT_SINK" required:"true"`
+	AuditFile          string `envconfig:"AUDIT_FILE"`
+	AuditKinesisStream string `envconfig:"AUDIT_KINESIS_STREAM"`
 }
 
 // PusherConfig contains configuration keys for services that use Pusher.
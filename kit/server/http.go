@@ -2,6 +2,7 @@ package server
 
 import (
 	"crypto/tls"
+	"github.com/ConnectCorp/go-kit/kit/utils"
 	"github.com/PuerkitoBio/rehttp"
 	"github.com/smartystreets/go-aws-auth"
 	"net"
@@ -62,8 +63,52 @@ func MakeAWSSigningHTTPClient(retry rehttp.RetryFn) *http.Client {
 	}
 }
 
+// ServiceTokenHTTPTransport is an HTTP transport that authenticates requests with a service token.
+type ServiceTokenHTTPTransport struct {
+	http.RoundTripper
+	TokenSource utils.ServiceTokenSource
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (s *ServiceTokenHTTPTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := s.TokenSource.GetServiceToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// A RoundTripper must not modify the request.
+	authReq := new(http.Request)
+	*authReq = *req
+	authReq.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		authReq.Header[k] = v
+	}
+	authReq.Header.Set("Authorization", "Bearer "+token)
+
+	return s.RoundTripper.RoundTrip(authReq)
+}
+
 // MakeProdHTTPClient makes an HTTP client suitable for use in production.
 func MakeProdHTTPClient(retry rehttp.RetryFn) *http.Client {
+	return &http.Client{
+		Transport: makeProdHTTPTransport(makeBaseHTTPTransport(), retry),
+	}
+}
+
+// MakeServiceHTTPClient makes an HTTP client suitable for calling other services in production.
+// Requests are authenticated with tokens from the given source, usually caching service tokens for the target service.
+func MakeServiceHTTPClient(retry rehttp.RetryFn, tokenSource utils.ServiceTokenSource) *http.Client {
+	return &http.Client{
+		Transport: makeProdHTTPTransport(
+			&ServiceTokenHTTPTransport{
+				RoundTripper: makeBaseHTTPTransport(),
+				TokenSource:  tokenSource,
+			},
+			retry),
+	}
+}
+
+func makeProdHTTPTransport(transport http.RoundTripper, retry rehttp.RetryFn) http.RoundTripper {
 	if retry == nil {
 		retry = rehttp.RetryAll(
 			rehttp.RetryMaxRetries(defaultMaxRetries),
@@ -74,20 +119,22 @@ func MakeProdHTTPClient(retry rehttp.RetryFn) *http.Client {
 		)
 	}
 
-	return &http.Client{
-		Transport: rehttp.NewTransport(
-			&http.Transport{
-				// Note that this ignores environment proxy settings for security reasons.
-				Dial: (&net.Dialer{
-					Timeout:   defaultDialerTimeout,
-					KeepAlive: defaultDialerKeepAliveTimeout,
-				}).Dial,
-				TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
-				ResponseHeaderTimeout: defaultResponseHeaderTimeout,
-				ExpectContinueTimeout: defaultExpectContinueTimeout,
-			},
-			retry,
-			rehttp.ExpJitterDelay(defaultBaseExpJitterDelay, defaultMaxExpJitterDelay)),
+	return rehttp.NewTransport(
+		transport,
+		retry,
+		rehttp.ExpJitterDelay(defaultBaseExpJitterDelay, defaultMaxExpJitterDelay))
+}
+
+func makeBaseHTTPTransport() *http.Transport {
+	return &http.Transport{
+		// Note that this ignores environment proxy settings for security reasons.
+		Dial: (&net.Dialer{
+			Timeout:   defaultDialerTimeout,
+			KeepAlive: defaultDialerKeepAliveTimeout,
+		}).Dial,
+		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
+		ResponseHeaderTimeout: defaultResponseHeaderTimeout,
+		ExpectContinueTimeout: defaultExpectContinueTimeout,
 	}
 }
 
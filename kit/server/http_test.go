@@ -46,3 +46,28 @@ func TestProdHTTPClient(t *testing.T) {
 	assert.True(t, visited)
 
 }
+
+type staticServiceTokenSource string
+
+func (s staticServiceTokenSource) GetServiceToken() (string, error) {
+	return string(s), nil
+}
+
+func TestServiceHTTPClient(t *testing.T) {
+	ts := test.NewTempServer()
+	defer ts.Close()
+
+	client := MakeServiceHTTPClient(nil, staticServiceTokenSource("token"))
+
+	authorization := ""
+	ts.SetResponder(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	})
+	req, err := http.NewRequest("GET", ts.URL("/test1"), nil)
+	assert.Nil(t, err)
+	_, err = client.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer token", authorization)
+	assert.Equal(t, "", req.Header.Get("Authorization"))
+}
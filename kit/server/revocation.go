@@ -0,0 +1,153 @@
+package server
+
+import (
+	"fmt"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"gopkg.in/redis.v3"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	errorUnableToRevokeToken     = "unable to revoke token"
+	errorUnableToRevokeSubject   = "unable to revoke subject"
+	errorUnableToRevokeFamily    = "unable to revoke family"
+	errorUnableToMarkTokenUsed   = "unable to mark token used"
+	errorUnableToCheckRevocation = "unable to check revocation"
+	defaultRevocationKeyPrefix   = "token-revocation"
+)
+
+// revokeSubjectScript stores the time before which the tokens of a subject are revoked, with a TTL in milliseconds, unless
+// a later time is already stored, so that a revocation cannot be weakened.
+var revokeSubjectScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]))
+if current and current >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`)
+
+// RedisTokenRevocationStore is a utils.TokenRevocationStore backed by Redis.
+type RedisTokenRevocationStore struct {
+	redisClient      *redis.Client
+	keyPrefix        string
+	maxTokenLifetime time.Duration
+}
+
+// NewRedisTokenRevocationStore initializes a new RedisTokenRevocationStore. Subject revocations are kept for the given
+// max token lifetime, after which all the tokens they apply to have expired.
+func NewRedisTokenRevocationStore(redisClient *redis.Client, keyPrefix string, maxTokenLifetime time.Duration) *RedisTokenRevocationStore {
+	return &RedisTokenRevocationStore{
+		redisClient:      redisClient,
+		keyPrefix:        keyPrefix,
+		maxTokenLifetime: maxTokenLifetime,
+	}
+}
+
+// MustInitRedisTokenRevocationStore initializes a new RedisTokenRevocationStore with default settings, or panics.
+func MustInitRedisTokenRevocationStore(spec *url.URL) *RedisTokenRevocationStore {
+	return NewRedisTokenRevocationStore(MustInitRedis(spec), defaultRevocationKeyPrefix, utils.DefaultRefreshTokenLifetime)
+}
+
+// RevokeToken implements the utils.TokenRevocationStore interface.
+func (s *RedisTokenRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	ttl := expiresAt.Sub(time.Now())
+	if ttl <= 0 {
+		return nil // Already expired.
+	}
+	if err := s.redisClient.Set(s.tokenKey(jti), 1, ttl).Err(); err != nil {
+		return xerror.Wrap(err, errorUnableToRevokeToken, jti)
+	}
+	return nil
+}
+
+// RevokeSubject implements the utils.TokenRevocationStore interface.
+func (s *RedisTokenRevocationStore) RevokeSubject(sub int64, issuedBefore time.Time) error {
+	ttl := issuedBefore.Add(s.maxTokenLifetime).Sub(time.Now()) / time.Millisecond
+	if ttl <= 0 {
+		return nil // All the tokens issued before have expired.
+	}
+	keys := []string{s.subjectKey(sub)}
+	args := []string{strconv.FormatInt(issuedBefore.UnixNano(), 10), strconv.FormatInt(int64(ttl), 10)}
+	if err := revokeSubjectScript.Run(s.redisClient, keys, args).Err(); err != nil {
+		return xerror.Wrap(err, errorUnableToRevokeSubject, sub)
+	}
+	return nil
+}
+
+// RevokeFamily implements the utils.TokenRevocationStore interface.
+func (s *RedisTokenRevocationStore) RevokeFamily(fam string, expiresAt time.Time) error {
+	ttl := expiresAt.Sub(time.Now())
+	if ttl <= 0 {
+		return nil // Already expired.
+	}
+	if err := s.redisClient.Set(s.familyKey(fam), 1, ttl).Err(); err != nil {
+		return xerror.Wrap(err, errorUnableToRevokeFamily, fam)
+	}
+	return nil
+}
+
+// MarkTokenUsed implements the utils.TokenRevocationStore interface.
+func (s *RedisTokenRevocationStore) MarkTokenUsed(jti string, expiresAt time.Time) (bool, error) {
+	ttl := expiresAt.Sub(time.Now())
+	if ttl <= 0 {
+		return false, nil // Expired tokens cannot be used.
+	}
+	isFirstUse, err := s.redisClient.SetNX(s.usedTokenKey(jti), 1, ttl).Result()
+	if err != nil {
+		return false, xerror.Wrap(err, errorUnableToMarkTokenUsed, jti)
+	}
+	return isFirstUse, nil
+}
+
+// IsRevoked implements the utils.TokenRevocationStore interface.
+func (s *RedisTokenRevocationStore) IsRevoked(claims *utils.TokenClaims) (bool, error) {
+	for _, key := range s.revocationKeys(claims) {
+		isRevoked, err := s.redisClient.Exists(key).Result()
+		if err != nil {
+			return false, xerror.Wrap(err, errorUnableToCheckRevocation, key)
+		}
+		if isRevoked {
+			return true, nil
+		}
+	}
+
+	issuedBefore, err := s.redisClient.Get(s.subjectKey(claims.Subject)).Int64()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, xerror.Wrap(err, errorUnableToCheckRevocation, claims.Subject)
+	}
+	return claims.IssuedAt.UnixNano() < issuedBefore, nil
+}
+
+func (s *RedisTokenRevocationStore) revocationKeys(claims *utils.TokenClaims) []string {
+	keys := make([]string, 0, 2)
+	if claims.ID != "" {
+		keys = append(keys, s.tokenKey(claims.ID))
+	}
+	if claims.Family != "" {
+		keys = append(keys, s.familyKey(claims.Family))
+	}
+	return keys
+}
+
+func (s *RedisTokenRevocationStore) tokenKey(jti string) string {
+	return fmt.Sprintf("%v:jti:%v", s.keyPrefix, jti)
+}
+
+func (s *RedisTokenRevocationStore) subjectKey(sub int64) string {
+	return fmt.Sprintf("%v:sub:%v", s.keyPrefix, sub)
+}
+
+func (s *RedisTokenRevocationStore) familyKey(fam string) string {
+	return fmt.Sprintf("%v:fam:%v", s.keyPrefix, fam)
+}
+
+func (s *RedisTokenRevocationStore) usedTokenKey(jti string) string {
+	return fmt.Sprintf("%v:used:%v", s.keyPrefix, jti)
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"github.com/ConnectCorp/go-kit/kit/service"
 	"github.com/prometheus/client_golang/prometheus"
 	"log"
@@ -14,9 +15,18 @@ const (
 
 // RunServer runs a server forever, until an error occurs.
 func RunServer(router *service.Router) {
+	runServer(router, nil)
+}
+
+// RunTLSServer runs a server forever, as RunServer, but serves the router using TLS. The metrics endpoint is unchanged.
+func RunTLSServer(router *service.Router, tlsConfig *tls.Config) {
+	runServer(router, tlsConfig)
+}
+
+func runServer(router *service.Router, tlsConfig *tls.Config) {
 	txErrChan := make(chan error)
 	http.Handle("/metrics", prometheus.Handler())
-	go func() { router.Run(":10000") }()
-	go func() { txErrChan <- http.ListenAndServe(":10001", nil) }()
+	go func() { txErrChan <- router.Serve(publicSpec, tlsConfig) }()
+	go func() { txErrChan <- http.ListenAndServe(privateSpec, nil) }()
 	log.Printf("exit: %v\n", <-txErrChan)
 }
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+)
+
+const (
+	errorInvalidTLSCertificate = "invalid tls certificate"
+	errorInvalidTLSClientCA    = "invalid tls client ca"
+)
+
+// MustInitServerTLSConfig initializes a new TLS config from config, or panics. If TLS_CLIENT_CA is set, client
+// certificates are requested and verified against it.
+func MustInitServerTLSConfig(cfg *ServerTLSConfig) *tls.Config {
+	cert, err := tls.X509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		panic(xerror.Wrap(err, errorInvalidTLSCertificate))
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if len(cfg.TLSClientCA) > 0 {
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(cfg.TLSClientCA) {
+			panic(xerror.New(errorInvalidTLSClientCA))
+		}
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.TLSClientCertRequired {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if cfg.TLSClientCertRequired {
+		panic(xerror.New(errorInvalidTLSClientCA))
+	}
+
+	return tlsConfig
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"crypto/tls"
+	"github.com/ConnectCorp/go-kit/kit/test"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMustInitServerTLSConfig(t *testing.T) {
+	ca := test.MustNewCertificate("ca", nil, nil)
+	serverCert := test.MustNewCertificate("server", []string{"localhost"}, ca)
+
+	tlsConfig := MustInitServerTLSConfig(&ServerTLSConfig{TLSCert: serverCert.CertPEM, TLSKey: serverCert.KeyPEM})
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.Nil(t, tlsConfig.ClientCAs)
+	assert.Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+
+	tlsConfig = MustInitServerTLSConfig(&ServerTLSConfig{TLSCert: serverCert.CertPEM, TLSKey: serverCert.KeyPEM, TLSClientCA: ca.CertPEM})
+	assert.NotNil(t, tlsConfig.ClientCAs)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+
+	tlsConfig = MustInitServerTLSConfig(&ServerTLSConfig{
+		TLSCert: serverCert.CertPEM, TLSKey: serverCert.KeyPEM, TLSClientCA: ca.CertPEM, TLSClientCertRequired: true})
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+
+	assert.Panics(t, func() { MustInitServerTLSConfig(&ServerTLSConfig{TLSCert: serverCert.CertPEM, TLSKey: ca.KeyPEM}) })
+	assert.Panics(t, func() {
+		MustInitServerTLSConfig(&ServerTLSConfig{TLSCert: serverCert.CertPEM, TLSKey: serverCert.KeyPEM, TLSClientCA: []byte("bad")})
+	})
+	assert.Panics(t, func() {
+		MustInitServerTLSConfig(&ServerTLSConfig{TLSCert: serverCert.CertPEM, TLSKey: serverCert.KeyPEM, TLSClientCertRequired: true})
+	})
+}
@@ -2,13 +2,25 @@ package server
 
 import (
 	"github.com/ConnectCorp/go-kit/kit/utils"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"sort"
+	"time"
 )
 
 // MustInitTokenIssuer initializes a new token issuer from conifg, or panics.
 func MustInitTokenIssuer(cfg *TokenIssuerConfig) utils.TokenIssuer {
+	options := make([]utils.TokenIssuerOption, 0, 1)
+	if cfg.JWTEncryptionKeyID != "" {
+		encryptionKey, ok := cfg.JWTEncryptionKeys[cfg.JWTEncryptionKeyID]
+		if !ok || len(encryptionKey) != utils.EncryptionKeySize {
+			panic(xerror.New(utils.ErrorInvalidEncryptionKey, cfg.JWTEncryptionKeyID))
+		}
+		options = append(options, utils.TokenIssuerEncryptionKey(cfg.JWTEncryptionKeyID, encryptionKey))
+	}
+
 	tokenIssuer, err := utils.NewTokenIssuer(
 		cfg.JWTKeyID, cfg.JWTKeyPrivate, cfg.JWTIssuer, cfg.JWTAudience,
-		utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+		utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime, options...)
 	if err != nil {
 		panic(err)
 	}
@@ -16,10 +28,72 @@ func MustInitTokenIssuer(cfg *TokenIssuerConfig) utils.TokenIssuer {
 }
 
 // MustInitTokenVerifier initializes a new token verifier from config, or panics.
-func MustInitTokenVerifier(cfg *TokenVerifierConfig) utils.TokenVerifier {
-	tokenVerifier, err := utils.NewTokenVerifier(cfg.JWTKeyID, cfg.JWTKeyPublic, cfg.JWTIssuer, cfg.JWTAudience)
+func MustInitTokenVerifier(cfg *TokenVerifierConfig, options ...utils.TokenVerifierOption) utils.TokenVerifier {
+	options = append(makeTokenVerifierOptions(cfg.JWTLeeway, cfg.JWTService, cfg.JWTServiceOnly, cfg.JWTEncryptionKeys), options...)
+	tokenVerifier, err := utils.NewMultiKeyTokenVerifier(MustGetTokenVerificationKeys(cfg), cfg.JWTIssuer, cfg.JWTAudience, options...)
 	if err != nil {
 		panic(err)
 	}
 	return tokenVerifier
 }
+
+// MustInitRemoteTokenVerifier initializes a new token verifier that fetches its keys from a JWKS URL, or panics.
+func MustInitRemoteTokenVerifier(commonCfg *CommonConfig, cfg *RemoteTokenVerifierConfig, options ...utils.TokenVerifierOption) utils.TokenVerifier {
+	options = append(makeTokenVerifierOptions(cfg.JWTLeeway, cfg.JWTService, cfg.JWTServiceOnly, cfg.JWTEncryptionKeys), options...)
+	tokenVerifier, err := utils.NewRemoteTokenVerifier(
+		cfg.JWTJWKSURL.URL.String(), MakeHTTPClientForConfig(commonCfg, nil), cfg.JWTIssuer, cfg.JWTAudience,
+		utils.DefaultJWKSCacheLifetime, options...)
+	if err != nil {
+		panic(err)
+	}
+	return tokenVerifier
+}
+
+func makeTokenVerifierOptions(leeway time.Duration, service string, serviceOnly bool, encryptionKeys utils.EnvBinaryMap) []utils.TokenVerifierOption {
+	options := []utils.TokenVerifierOption{utils.TokenVerifierLeeway(leeway)}
+	if service != "" {
+		options = append(options, utils.TokenVerifierServiceAudience(service, serviceOnly))
+	}
+	if len(encryptionKeys) > 0 {
+		for keyID, encryptionKey := range encryptionKeys {
+			if len(encryptionKey) != utils.EncryptionKeySize {
+				panic(xerror.New(utils.ErrorInvalidEncryptionKey, keyID))
+			}
+		}
+		options = append(options, utils.TokenVerifierEncryptionKeys(encryptionKeys))
+	}
+	return options
+}
+
+// MustGetTokenVerificationKeys collects the public keys from config, or panics.
+func MustGetTokenVerificationKeys(cfg *TokenVerifierConfig) []*utils.TokenVerificationKey {
+	keys := make([]*utils.TokenVerificationKey, 0, len(cfg.JWTKeysPublic)+1)
+
+	if cfg.JWTKeyID != "" || len(cfg.JWTKeyPublic) > 0 {
+		keys = append(keys, &utils.TokenVerificationKey{KeyID: cfg.JWTKeyID, PublicKey: cfg.JWTKeyPublic})
+	}
+
+	keyIDs := make([]string, 0, len(cfg.JWTKeysPublic))
+	for keyID := range cfg.JWTKeysPublic {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	for _, keyID := range keyIDs {
+		keys = append(keys, &utils.TokenVerificationKey{KeyID: keyID, PublicKey: cfg.JWTKeysPublic[keyID]})
+	}
+
+	for keyID, retiresAt := range cfg.JWTKeysRetireAt {
+		found := false
+		for _, key := range keys {
+			if key.KeyID == keyID {
+				key.RetiresAt = retiresAt
+				found = true
+			}
+		}
+		if !found {
+			panic(xerror.New(utils.ErrorInvalidKeyID, keyID))
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ErrorAmbiguousAuthentication is returned when a request carries both a token and an API key.
+	ErrorAmbiguousAuthentication = "ambiguous authentication"
+)
+
+const (
+	// APIKeyRole is the authorized role of requests authenticated by an API key.
+	APIKeyRole = "api-key"
+
+	apiKeyHeader             = utils.APIKeyHeader
+	ctxLabelAPIKey           = "apiKey"
+	ctxLabelAuthorizedAPIKey = "authorizedAPIKey"
+)
+
+func ctxWithAPIKey(ctx context.Context, apiKey string) context.Context {
+	return context.WithValue(ctx, ctxLabelAPIKey, apiKey)
+}
+
+func ctxAPIKey(ctx context.Context) string {
+	return strings.TrimSpace(EnsureString(ctx, ctxLabelAPIKey))
+}
+
+func ctxWithAuthorizedAPIKey(ctx context.Context, apiKey *utils.APIKey) context.Context {
+	return context.WithValue(ctx, ctxLabelAuthorizedAPIKey, apiKey)
+}
+
+func ctxAuthorizedAPIKey(ctx context.Context) *utils.APIKey {
+	if v, ok := ctx.Value(ctxLabelAuthorizedAPIKey).(*utils.APIKey); ok {
+		return v
+	}
+	return nil
+}
+
+// CtxAuthorizedAPIKeyOwner extracts the owner of the verified API key stored in the request context, if the request is
+// authenticated by an API key.
+func CtxAuthorizedAPIKeyOwner(ctx context.Context) (string, bool) {
+	if apiKey := ctxAuthorizedAPIKey(ctx); apiKey != nil {
+		return apiKey.Owner, true
+	}
+	return "", false
+}
+
+// APIKeyExtractor is a go-kit before handler that extracts an API key from the X-Connect-API-Key header into the context.
+func APIKeyExtractor(ctx context.Context, r *http.Request) context.Context {
+	apiKey := r.Header.Get(apiKeyHeader)
+	if apiKey != "" {
+		return ctxWithAPIKey(ctx, apiKey)
+	}
+	return ctx
+}
+
+// NewAPIKeyMiddleware requires a valid API key for the request, attaches the verified API key and its scopes in the context.
+func NewAPIKeyMiddleware(apiKeyStore utils.APIKeyStore) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (resp interface{}, err error) {
+			if ctx, err = requireAPIKey(ctx, apiKeyStore); err != nil {
+				return nil, auditAuthenticationFailure(ctx, err)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// NewTokenOrAPIKeyMiddleware requires either a valid token or a valid API key for the request, as NewTokenMiddleware or
+// NewAPIKeyMiddleware respectively.
+func NewTokenOrAPIKeyMiddleware(tokenVerifier utils.TokenVerifier, apiKeyStore utils.APIKeyStore) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (resp interface{}, err error) {
+			if ctx, err = requireTokenOrAPIKey(ctx, tokenVerifier, apiKeyStore); err != nil {
+				return nil, auditAuthenticationFailure(ctx, err)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// NewOptionalTokenOrAPIKeyMiddleware verifies either the token or the API key attached to the request, as
+// NewTokenOrAPIKeyMiddleware, and otherwise lets the request through anonymously.
+func NewOptionalTokenOrAPIKeyMiddleware(tokenVerifier utils.TokenVerifier, apiKeyStore utils.APIKeyStore) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (resp interface{}, err error) {
+			if ctx, err = checkTokenOrAPIKey(ctx, tokenVerifier, apiKeyStore); err != nil {
+				return nil, auditAuthenticationFailure(ctx, err)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+func requireTokenOrAPIKey(ctx context.Context, tokenVerifier utils.TokenVerifier, apiKeyStore utils.APIKeyStore) (context.Context, error) {
+	switch {
+	case ctxAPIKey(ctx) == "":
+		return requireToken(ctx, tokenVerifier)
+	case getToken(ctx) == "":
+		return requireAPIKey(ctx, apiKeyStore)
+	default:
+		return ctx, xerror.Wrap(xerror.New(ErrorAmbiguousAuthentication), ErrorBadRequest, ctx)
+	}
+}
+
+func checkTokenOrAPIKey(ctx context.Context, tokenVerifier utils.TokenVerifier, apiKeyStore utils.APIKeyStore) (context.Context, error) {
+	if ctxAPIKey(ctx) == "" {
+		return checkToken(ctx, tokenVerifier)
+	}
+	return requireTokenOrAPIKey(ctx, tokenVerifier, apiKeyStore)
+}
+
+func requireAPIKey(ctx context.Context, apiKeyStore utils.APIKeyStore) (context.Context, error) {
+	key := ctxAPIKey(ctx)
+	if key == "" {
+		return ctx, xerror.Wrap(xerror.New(ErrorMissingToken), ErrorUnauthorized, ctx)
+	}
+	return authenticateOnce(ctx, verification{credential: key, verifier: apiKeyStore}, func(ctx context.Context) (context.Context, error) {
+		return verifyAPIKey(ctx, apiKeyStore, key)
+	})
+}
+
+func verifyAPIKey(ctx context.Context, apiKeyStore utils.APIKeyStore, key string) (context.Context, error) {
+	apiKey, err := utils.VerifyAPIKey(apiKeyStore, key)
+	if err != nil {
+		return ctx, xerror.Wrap(err, ErrorUnauthorized)
+	}
+	ctx = ctxWithAuthorizedScopes(ctxWithAuthorizedAPIKey(ctx, apiKey), apiKey.Scopes)
+	return ctxWithAuthorizedRole(ctxWithAuthorizedSub(ctx, 0), APIKeyRole), nil
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"github.com/ConnectCorp/go-kit/kit/test"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	store := utils.NewMemoryAPIKeyStore()
+	key, apiKey, err := utils.NewAPIKey("partner-a", "messages:read")
+	assert.Nil(t, err)
+	assert.Nil(t, store.StoreAPIKey(apiKey))
+
+	req := test.MustNewRequest()
+	req.Header.Set(apiKeyHeader, key)
+	ctx := APIKeyExtractor(context.Background(), req)
+	assert.Equal(t, key, ctxAPIKey(ctx))
+	assert.Equal(t, "", ctxAPIKey(APIKeyExtractor(context.Background(), test.MustNewRequest())))
+
+	apiKeyFunc := NewAPIKeyMiddleware(store)(func(ctx context.Context, request interface{}) (interface{}, error) {
+		owner, ok := CtxAuthorizedAPIKeyOwner(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, "partner-a", owner)
+		assert.Equal(t, []string{"messages:read"}, ctxAuthorizedScopes(ctx))
+		return nil, NewContextAuthVerifier(ctx).Verify()
+	})
+	_, err = apiKeyFunc(ctx, req)
+	assert.Equal(t, "forbidden", err.Error())
+	_, err = apiKeyFunc(context.Background(), req)
+	assert.Equal(t, "unauthorized: missing token", err.Error())
+	_, err = apiKeyFunc(ctxWithAPIKey(context.Background(), "bad"), req)
+	assert.Equal(t, "unauthorized: invalid api key", err.Error())
+
+	_, err = NewNoTokenMiddleware()(test.TerminationMiddleware)(ctx, req)
+	assert.Equal(t, "bad request: must not authenticate", err.Error())
+}
+
+func TestAuthVerifierWithAPIKey(t *testing.T) {
+	apiKeyCtx := ctxWithAuthorizedAPIKey(ctxWithAuthorizedRole(context.Background(), APIKeyRole), &utils.APIKey{Owner: "partner-a"})
+	scopedAPIKeyCtx := ctxWithAuthorizedScopes(apiKeyCtx, []string{"messages:read"})
+	userTokenCtx := ctxWithAuthorizedSub(ctxWithAuthorizedRole(context.Background(), utils.TokenAccessUserRole), 1)
+
+	role, sub, err := NewContextAuthVerifier(apiKeyCtx).AcceptAnyAPIKey().VerifyAndGet()
+	assert.Nil(t, err)
+	assert.Equal(t, APIKeyRole, role)
+	assert.EqualValues(t, 0, sub)
+	assert.False(t, NewContextAuthVerifier(apiKeyCtx).IsAnonymous())
+
+	assert.NotNil(t, NewContextAuthVerifier(apiKeyCtx).Verify())
+	assert.NotNil(t, NewContextAuthVerifier(apiKeyCtx).AcceptAnyAccessUserToken().AcceptAccessSystemToken().Verify())
+	assert.Nil(t, NewContextAuthVerifier(apiKeyCtx).AcceptAPIKeyForOwners("partner-a").Verify())
+	assert.NotNil(t, NewContextAuthVerifier(apiKeyCtx).AcceptAPIKeyForOwners("partner-b").Verify())
+	assert.NotNil(t, NewContextAuthVerifier(userTokenCtx).AcceptAnyAPIKey().Verify())
+	assert.NotNil(t, NewContextAuthVerifier(apiKeyCtx).AcceptAnyAPIKey().RequireScopes("messages:read").Verify())
+	assert.Nil(t, NewContextAuthVerifier(scopedAPIKeyCtx).AcceptAnyAPIKey().RequireScopes("messages:read").Verify())
+
+	assert.Nil(t, MustNewRolesPolicy(APIKeyRole).Authorize(apiKeyCtx))
+	assert.NotNil(t, MustNewRolesPolicy(APIKeyRole).Authorize(userTokenCtx))
+	assert.Nil(t, NewAPIKeyPolicy("messages:read").Authorize(scopedAPIKeyCtx))
+	assert.NotNil(t, NewAPIKeyPolicy("messages:read").Authorize(apiKeyCtx))
+}
+
+func TestRouterWithAPIKeyStore(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	token, err := ti.IssueAccessUserToken(1)
+	assert.Nil(t, err)
+	store := utils.NewMemoryAPIKeyStore()
+	key, apiKey, err := utils.NewAPIKey("partner-a", "messages:read")
+	assert.Nil(t, err)
+	assert.Nil(t, store.StoreAPIKey(apiKey))
+
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil).SetAPIKeyStore(store)
+	router.MountRoute(newTestPolicyRoute("/partner", NewAnyOfPolicy(NewAPIKeyPolicy("messages:read"), NewUserPolicy())))
+	router.MountRoute(newTestPolicyRoute("/user", NewUserPolicy()))
+	optionalRoute := newTestPolicyRoute("/optional", nil)
+	optionalRoute.AuthenticationMixin = NewOptionalAuthenticationMixin()
+	router.MountRoute(optionalRoute)
+	ts := httptest.NewServer(router.GetMux())
+	defer ts.Close()
+
+	get := func(path, token, key string) int {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		assert.Nil(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("X-Connect-API-Key", key)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Nil(t, res.Body.Close())
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, get("/v1/partner", "", key))
+	assert.Equal(t, http.StatusOK, get("/v1/partner", token, ""))
+	assert.Equal(t, http.StatusBadRequest, get("/v1/partner", token, key))
+	assert.Equal(t, http.StatusUnauthorized, get("/v1/partner", "", "bad"))
+	assert.Equal(t, http.StatusUnauthorized, get("/v1/partner", "", ""))
+	assert.Equal(t, http.StatusForbidden, get("/v1/user", "", key))
+	assert.Equal(t, http.StatusOK, get("/v1/user", token, ""))
+	assert.Equal(t, http.StatusOK, get("/v1/optional", "", ""))
+	assert.Equal(t, http.StatusOK, get("/v1/optional", token, ""))
+	assert.Equal(t, http.StatusOK, get("/v1/optional", "", key))
+	assert.Equal(t, http.StatusUnauthorized, get("/v1/optional", "", "bad"))
+	assert.Equal(t, http.StatusBadRequest, get("/v1/optional", token, key))
+
+	assert.Nil(t, store.RevokeAPIKey(apiKey.ID))
+	assert.Equal(t, http.StatusUnauthorized, get("/v1/partner", "", key))
+	assert.Equal(t, http.StatusUnauthorized, get("/v1/optional", "", key))
+}
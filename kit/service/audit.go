@@ -0,0 +1,183 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/go-kit/kit/endpoint"
+	kitlog "github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// AuditDecisionAllow is the decision of an AuditEvent for an authorized request.
+	AuditDecisionAllow = "allow"
+	// AuditDecisionDeny is the decision of an AuditEvent for a request that failed authentication or authorization.
+	AuditDecisionDeny = "deny"
+)
+
+const (
+	auditKey                         = "audit"
+	ctxLabelAuditor                  = "auditor"
+	auditReasonMissingScopes         = "missing scopes"
+	auditReasonMissingClientIdentity = "missing client identity"
+	auditReasonDelegatedToken        = "delegated token"
+	auditReasonRoleNotAccepted       = "role not accepted"
+)
+
+// AuditEvent describes an authorization decision. Reason is empty for allowed requests, otherwise it explains the
+// denial, e.g. "missing scopes" or the authentication error.
+type AuditEvent struct {
+	Time          time.Time `json:"time"`
+	Decision      string    `json:"decision"`
+	Reason        string    `json:"reason,omitempty"`
+	Role          string    `json:"role,omitempty"`
+	Sub           int64     `json:"sub"`
+	Route         string    `json:"route"`
+	Path          string    `json:"path"`
+	TraceID       string    `json:"traceId,omitempty"`
+	ClientType    string    `json:"clientType,omitempty"`
+	ClientVersion string    `json:"clientVersion,omitempty"`
+}
+
+// AuditSink records the authorization decisions of a Router.
+type AuditSink interface {
+	WriteAuditEvent(event *AuditEvent) error
+}
+
+// LoggerAuditSink is an AuditSink that writes events to a kit logger.
+type LoggerAuditSink struct {
+	logger kitlog.Logger
+}
+
+// NewLoggerAuditSink initializes a new LoggerAuditSink.
+func NewLoggerAuditSink(logger kitlog.Logger) *LoggerAuditSink {
+	return &LoggerAuditSink{logger: kitlog.NewContext(logger).With(auditKey, true)}
+}
+
+// WriteAuditEvent implements the AuditSink interface.
+func (s *LoggerAuditSink) WriteAuditEvent(event *AuditEvent) error {
+	return s.logger.Log(
+		"decision", event.Decision,
+		"reason", event.Reason,
+		"role", event.Role,
+		subKey, event.Sub,
+		"route", event.Route,
+		actionKey, event.Path,
+		ctxLabelTraceID, event.TraceID,
+		ctxLabelClientType, event.ClientType,
+		ctxLabelClientVersion, event.ClientVersion)
+}
+
+// WriterAuditSink is an AuditSink that writes events to an io.Writer as JSON, one per line.
+type WriterAuditSink struct {
+	m sync.Mutex
+	w io.Writer
+}
+
+// NewWriterAuditSink initializes a new WriterAuditSink.
+func NewWriterAuditSink(w io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{w: w}
+}
+
+// WriteAuditEvent implements the AuditSink interface.
+func (s *WriterAuditSink) WriteAuditEvent(event *AuditEvent) error {
+	buf, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	_, err = s.w.Write(append(buf, '\n'))
+	return err
+}
+
+// auditor records the authorization decisions of a route.
+type auditor struct {
+	sink   AuditSink
+	route  string
+	logger kitlog.Logger
+}
+
+func ctxWithAuditor(ctx context.Context, auditor *auditor) context.Context {
+	return context.WithValue(ctx, ctxLabelAuditor, auditor)
+}
+
+func ctxAuditor(ctx context.Context) *auditor {
+	if v, ok := ctx.Value(ctxLabelAuditor).(*auditor); ok {
+		return v
+	}
+	return nil
+}
+
+// NewAuditMiddleware records the authorization decisions made for the given route in the AuditSink. It must wrap the
+// token middleware. Failures to write an event are logged, but do not fail the request.
+func NewAuditMiddleware(sink AuditSink, route string, logger kitlog.Logger) endpoint.Middleware {
+	auditor := &auditor{sink: sink, route: route, logger: logger}
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			return next(ctxWithAuditor(ctx, auditor), request)
+		}
+	}
+}
+
+func auditDecision(ctx context.Context, decision, reason string) {
+	auditor := ctxAuditor(ctx)
+	if auditor == nil {
+		return
+	}
+	event := &AuditEvent{
+		Time:          time.Now().UTC(),
+		Decision:      decision,
+		Reason:        reason,
+		Role:          ctxAuthorizedRole(ctx),
+		Sub:           ctxAuthorizedSub(ctx),
+		Route:         auditor.route,
+		Path:          ctxRequestPath(ctx),
+		TraceID:       CtxTraceID(ctx),
+		ClientType:    ctxClientType(ctx),
+		ClientVersion: ctxClientVersion(ctx),
+	}
+	if err := auditor.sink.WriteAuditEvent(event); err != nil {
+		auditor.logger.Log(auditKey, event.Decision, ctxLabelTraceID, event.TraceID, errorKey, err)
+	}
+}
+
+func auditAuthenticationFailure(ctx context.Context, err error) error {
+	auditDecision(ctx, AuditDecisionDeny, err.Error())
+	return err
+}
+
+// auditDenialRecorder is an AuditSink that keeps the reason of the last denial, instead of writing events.
+type auditDenialRecorder struct {
+	reason string
+}
+
+// WriteAuditEvent implements the AuditSink interface.
+func (r *auditDenialRecorder) WriteAuditEvent(event *AuditEvent) error {
+	if event.Decision == AuditDecisionDeny {
+		r.reason = event.Reason
+	}
+	return nil
+}
+
+// auditOnce authorizes the request using f, which may make several decisions, and only records the outcome: allowed if
+// f returns nil, and otherwise denied for the reason of the last denial.
+func auditOnce(ctx context.Context, f func(ctx context.Context) error) error {
+	outer := ctxAuditor(ctx)
+	if outer == nil {
+		return f(ctx)
+	}
+	recorder := &auditDenialRecorder{}
+	err := f(ctxWithAuditor(ctx, &auditor{sink: recorder, route: outer.route, logger: outer.logger}))
+	if err == nil {
+		auditDecision(ctx, AuditDecisionAllow, "")
+		return nil
+	}
+	if recorder.reason == "" {
+		recorder.reason = err.Error()
+	}
+	auditDecision(ctx, AuditDecisionDeny, recorder.reason)
+	return err
+}
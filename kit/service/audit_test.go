@@ -0,0 +1,168 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ConnectCorp/go-kit/kit/test"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testAuditSink struct {
+	events []*AuditEvent
+	err    error
+}
+
+func (s *testAuditSink) WriteAuditEvent(event *AuditEvent) error {
+	s.events = append(s.events, event)
+	return s.err
+}
+
+func TestAuditAuthVerifier(t *testing.T) {
+	sink := &testAuditSink{}
+	auditMiddleware := NewAuditMiddleware(sink, "GET /messages", NewRootLogger(os.Stdout))
+	verify := func(ctx context.Context, configure func(av AuthVerifier) AuthVerifier) error {
+		_, err := auditMiddleware(func(ctx context.Context, _ interface{}) (interface{}, error) {
+			return nil, configure(NewContextAuthVerifier(ctx)).Verify()
+		})(ctx, nil)
+		return err
+	}
+	userCtx := ctxWithTraceID(makeTestPolicyCtx(utils.TokenAccessUserRole, 1, "", "messages:read"), "trace")
+
+	assert.Nil(t, verify(userCtx, func(av AuthVerifier) AuthVerifier { return av.AcceptAnyAccessUserToken() }))
+	assert.NotNil(t, verify(userCtx, func(av AuthVerifier) AuthVerifier { return av.AcceptAccessSystemToken() }))
+	assert.NotNil(t, verify(userCtx, func(av AuthVerifier) AuthVerifier {
+		return av.AcceptAnyAccessUserToken().RequireScopes("messages:write")
+	}))
+	assert.NotNil(t, verify(userCtx, func(av AuthVerifier) AuthVerifier {
+		return av.AcceptAnyAccessUserToken().RequireClientIdentities("billing")
+	}))
+
+	assert.Len(t, sink.events, 4)
+	assert.Equal(t, AuditDecisionAllow, sink.events[0].Decision)
+	assert.Equal(t, "", sink.events[0].Reason)
+	assert.Equal(t, utils.TokenAccessUserRole, sink.events[0].Role)
+	assert.EqualValues(t, 1, sink.events[0].Sub)
+	assert.Equal(t, "GET /messages", sink.events[0].Route)
+	assert.Equal(t, "trace", sink.events[0].TraceID)
+	assert.Equal(t, AuditDecisionDeny, sink.events[1].Decision)
+	assert.Equal(t, "role not accepted", sink.events[1].Reason)
+	assert.Equal(t, "missing scopes", sink.events[2].Reason)
+	assert.Equal(t, "missing client identity", sink.events[3].Reason)
+
+	sink.err = xerror.New("broken")
+	assert.Nil(t, verify(userCtx, func(av AuthVerifier) AuthVerifier { return av.AcceptAnyAccessUserToken() }))
+	assert.Nil(t, NewContextAuthVerifier(userCtx).AcceptAnyAccessUserToken().Verify())
+	assert.Len(t, sink.events, 5)
+}
+
+func TestAuditAnyOfPolicy(t *testing.T) {
+	sink := &testAuditSink{}
+	ctx := ctxWithAuditor(makeTestPolicyCtx(utils.TokenAccessUserRole, 1, "", "messages:read"), &auditor{sink: sink, route: "GET /messages"})
+
+	assert.Nil(t, NewAnyOfPolicy(NewSystemPolicy(), NewUserPolicy()).Authorize(ctx))
+	assert.Len(t, sink.events, 1)
+	assert.Equal(t, AuditDecisionAllow, sink.events[0].Decision)
+	assert.Equal(t, "GET /messages", sink.events[0].Route)
+
+	assert.NotNil(t, NewAnyOfPolicy(NewSystemPolicy(), NewUserPolicy("messages:write")).Authorize(ctx))
+	assert.Len(t, sink.events, 2)
+	assert.Equal(t, AuditDecisionDeny, sink.events[1].Decision)
+	assert.Equal(t, "missing scopes", sink.events[1].Reason)
+
+	assert.NotNil(t, NewAnyOfPolicy().Authorize(ctx))
+	assert.Len(t, sink.events, 3)
+	assert.Equal(t, "forbidden", sink.events[2].Reason)
+
+	assert.Nil(t, NewAnyOfPolicy(NewSystemPolicy(), NewAnyOfPolicy(NewAPIKeyPolicy(), NewUserPolicy())).Authorize(ctx))
+	assert.Len(t, sink.events, 4)
+	assert.Equal(t, AuditDecisionAllow, sink.events[3].Decision)
+}
+
+func TestAuditAuthenticationFailure(t *testing.T) {
+	sink := &testAuditSink{}
+	ctx := ctxWithAuditor(ctxWithClientVersion(ctxWithClientType(context.Background(), "ios"), "1.0"), &auditor{sink: sink, route: "GET /messages"})
+
+	_, err := NewTokenMiddleware(nil)(test.TerminationMiddleware)(ctx, nil)
+	assert.NotNil(t, err)
+	_, err = NewNoTokenMiddleware()(test.TerminationMiddleware)(ctxWithToken(ctx, "token"), nil)
+	assert.NotNil(t, err)
+
+	assert.Len(t, sink.events, 2)
+	assert.Equal(t, AuditDecisionDeny, sink.events[0].Decision)
+	assert.Equal(t, "unauthorized: missing token", sink.events[0].Reason)
+	assert.Equal(t, "", sink.events[0].Role)
+	assert.Equal(t, "ios", sink.events[0].ClientType)
+	assert.Equal(t, "1.0", sink.events[0].ClientVersion)
+	assert.Equal(t, "bad request: must not authenticate", sink.events[1].Reason)
+}
+
+func TestAuditSinks(t *testing.T) {
+	event := &AuditEvent{Decision: AuditDecisionDeny, Reason: "missing scopes", Role: utils.TokenAccessUserRole, Sub: 1, Route: "GET /messages"}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, NewLoggerAuditSink(NewRootLogger(buf)).WriteAuditEvent(event))
+	assert.Contains(t, buf.String(), `"audit":true`)
+	assert.Contains(t, buf.String(), `"reason":"missing scopes"`)
+
+	buf.Reset()
+	sink := NewWriterAuditSink(buf)
+	assert.Nil(t, sink.WriteAuditEvent(event))
+	assert.Nil(t, sink.WriteAuditEvent(event))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	decoded := &AuditEvent{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), decoded))
+	assert.Equal(t, event, decoded)
+}
+
+func TestRouterWithAuditSink(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	token, err := ti.IssueAccessUserToken(1)
+	assert.Nil(t, err)
+
+	sink := &testAuditSink{}
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil).SetAuditSink(sink)
+	router.MountRoute(newTestPolicyRoute("/user", NewUserPolicy()))
+	router.MountRoute(newTestPolicyRoute("/system", NewSystemPolicy()))
+	ts := httptest.NewServer(router.GetMux())
+	defer ts.Close()
+
+	get := func(path, token string) int {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		assert.Nil(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set(traceIDHeader, "trace")
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Nil(t, res.Body.Close())
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, get("/v1/user", token))
+	assert.Equal(t, http.StatusForbidden, get("/v1/system", token))
+	assert.Equal(t, http.StatusUnauthorized, get("/v1/user", ""))
+
+	assert.Len(t, sink.events, 3)
+	assert.Equal(t, AuditDecisionAllow, sink.events[0].Decision)
+	assert.Equal(t, "GET /user", sink.events[0].Route)
+	assert.Equal(t, "/v1/user", sink.events[0].Path)
+	assert.Equal(t, "trace", sink.events[0].TraceID)
+	assert.Equal(t, AuditDecisionDeny, sink.events[1].Decision)
+	assert.Equal(t, "role not accepted", sink.events[1].Reason)
+	assert.Equal(t, "GET /system", sink.events[1].Route)
+	assert.Equal(t, AuditDecisionDeny, sink.events[2].Decision)
+	assert.Equal(t, "unauthorized: missing token", sink.events[2].Reason)
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"net/http"
+)
+
+// Default codes of error responses, by error class.
+const (
+	ErrorCodeBadRequest    = "bad_request"
+	ErrorCodeInvalidFields = "invalid_fields"
+	ErrorCodeUnauthorized  = "unauthorized"
+	ErrorCodeForbidden     = "forbidden"
+	ErrorCodeNotFound      = "not_found"
+	ErrorCodeUnexpected    = "unexpected"
+)
+
+// ErrorCode is a stable, machine-readable code attached to an error as debug object, returned to clients in the
+// ErrorResponse instead of the default code of the error class.
+type ErrorCode string
+
+// NewErrorWithCode creates a new error of the given class, e.g. ErrorNotFound, with a code and optional field details.
+func NewErrorWithCode(msg, code string, details ...*utils.FieldError) error {
+	if len(details) > 0 {
+		return xerror.New(msg, ErrorCode(code), details)
+	}
+	return xerror.New(msg, ErrorCode(code))
+}
+
+// WrapErrorWithCode wraps an error in the given class, e.g. ErrorBadRequest, with a code and optional field details.
+func WrapErrorWithCode(err error, msg, code string, details ...*utils.FieldError) error {
+	if len(details) > 0 {
+		return xerror.Wrap(err, msg, ErrorCode(code), details)
+	}
+	return xerror.Wrap(err, msg, ErrorCode(code))
+}
+
+// GetErrorCode extracts the outermost code attached to an error, if any.
+func GetErrorCode(err error) (string, bool) {
+	if xerr, ok := err.(interface {
+		DebugObjects() []interface{}
+	}); ok {
+		for _, debugObject := range xerr.DebugObjects() {
+			if code, ok := debugObject.(ErrorCode); ok {
+				return string(code), true
+			}
+		}
+	}
+	return "", false
+}
+
+// NewErrorResponse converts an error to the corresponding ErrorResponse. The message of errors with a 5xx status code
+// is replaced by ErrorUnexpected, as it may contain internal details.
+func NewErrorResponse(ctx context.Context, err error) *ErrorResponse {
+	resp := &ErrorResponse{
+		Error:   err.Error(),
+		Code:    errorToDefaultCode(err),
+		TraceID: CtxTraceID(ctx),
+	}
+	if details, ok := utils.GetFieldErrors(err); ok {
+		resp.Code = ErrorCodeInvalidFields
+		resp.Details = details
+	}
+	if code, ok := GetErrorCode(err); ok {
+		resp.Code = code
+	}
+	if ErrorToStatusCode(err) >= http.StatusInternalServerError {
+		resp.Error = ErrorUnexpected
+		resp.Details = nil
+	}
+	return resp
+}
+
+func errorToDefaultCode(err error) string {
+	switch ErrorToStatusCode(err) {
+	case http.StatusBadRequest:
+		return ErrorCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrorCodeForbidden
+	case http.StatusNotFound:
+		return ErrorCodeNotFound
+	default:
+		return ErrorCodeUnexpected
+	}
+}
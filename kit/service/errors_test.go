@@ -0,0 +1,56 @@
+package service
+
+import (
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"net/http"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	err := NewErrorWithCode(ErrorNotFound, "user_not_found")
+	assert.Equal(t, "not found", err.Error())
+	assert.Equal(t, http.StatusNotFound, ErrorToStatusCode(err))
+	code, ok := GetErrorCode(err)
+	assert.True(t, ok)
+	assert.Equal(t, "user_not_found", code)
+
+	details := []*utils.FieldError{{Field: "email", Reason: "taken"}}
+	err = WrapErrorWithCode(xerror.New("duplicate entry"), ErrorBadRequest, "email_taken", details...)
+	assert.Equal(t, "bad request: duplicate entry", err.Error())
+	code, ok = GetErrorCode(xerror.Wrap(err, "outer"))
+	assert.True(t, ok)
+	assert.Equal(t, "email_taken", code)
+	fieldErrors, ok := utils.GetFieldErrors(err)
+	assert.True(t, ok)
+	assert.Equal(t, details, fieldErrors)
+
+	_, ok = GetErrorCode(xerror.New(ErrorNotFound))
+	assert.False(t, ok)
+}
+
+func TestNewErrorResponse(t *testing.T) {
+	ctx := ctxWithTraceID(context.Background(), "trace")
+	details := []*utils.FieldError{{Field: "kind", Reason: utils.TagEnum}}
+
+	assert.Equal(t,
+		&ErrorResponse{Error: "forbidden", Code: ErrorCodeForbidden, TraceID: "trace"},
+		NewErrorResponse(ctx, xerror.New(ErrorForbidden)))
+	assert.Equal(t,
+		&ErrorResponse{Error: "unauthorized: missing token", Code: ErrorCodeUnauthorized},
+		NewErrorResponse(context.Background(), xerror.Wrap(xerror.New(ErrorMissingToken), ErrorUnauthorized)))
+	assert.Equal(t,
+		&ErrorResponse{Error: "bad request: invalid fields: kind", Code: ErrorCodeInvalidFields, Details: details, TraceID: "trace"},
+		NewErrorResponse(ctx, xerror.Wrap(utils.NewInvalidFieldsError(details), ErrorBadRequest)))
+	assert.Equal(t,
+		&ErrorResponse{Error: "bad request", Code: "invalid_kind", Details: details, TraceID: "trace"},
+		NewErrorResponse(ctx, NewErrorWithCode(ErrorBadRequest, "invalid_kind", details...)))
+	assert.Equal(t,
+		&ErrorResponse{Error: ErrorUnexpected, Code: "upstream_unavailable", TraceID: "trace"},
+		NewErrorResponse(ctx, WrapErrorWithCode(xerror.New("dial tcp 10.0.0.1:443"), ErrorUnexpected, "upstream_unavailable", details...)))
+	assert.Equal(t,
+		&ErrorResponse{Error: ErrorUnexpected, Code: ErrorCodeUnexpected, TraceID: "trace"},
+		NewErrorResponse(ctx, xerror.New("secret internal failure")))
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ErrorMissingIntrospectedToken is returned when the introspection request does not include a token.
+	ErrorMissingIntrospectedToken = "missing introspected token"
+)
+
+const (
+	introspectionTokenParam = "token"
+	noStoreHeaderValue      = "no-store"
+)
+
+// IntrospectionResponse is the response of the token introspection route, as defined in RFC 7662.
+// Inactive tokens only report Active = false. Custom claims of single purpose tokens are returned as top-level members.
+type IntrospectionResponse struct {
+	Active       bool
+	Sub          string
+	Role         string
+	Scope        string
+	JTI          string
+	ActorSub     string
+	Exp          int64
+	Iat          int64
+	CustomClaims map[string]interface{}
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r *IntrospectionResponse) MarshalJSON() ([]byte, error) {
+	if !r.Active {
+		return json.Marshal(map[string]interface{}{"active": false})
+	}
+
+	fields := make(map[string]interface{}, len(r.CustomClaims)+7)
+	for cN, cV := range r.CustomClaims {
+		fields[cN] = cV
+	}
+	fields["active"] = true
+	fields["sub"] = r.Sub
+	fields["role"] = r.Role
+	fields["exp"] = r.Exp
+	fields["iat"] = r.Iat
+	if r.Scope != "" {
+		fields["scope"] = r.Scope
+	}
+	if r.JTI != "" {
+		fields["jti"] = r.JTI
+	}
+	if r.ActorSub != "" {
+		fields["act"] = map[string]interface{}{"sub": r.ActorSub}
+	}
+	return json.Marshal(fields)
+}
+
+// IntrospectionRoute is a Route implementing OAuth2 token introspection (RFC 7662).
+// It accepts form-encoded POST requests, and can only be called with an access system token.
+type IntrospectionRoute struct {
+	AuthenticationMixin
+	MethodAndPathMixin
+	JSONErrorEncoderMixin
+	AdvancedRouteMixin
+	RoutePolicyMixin
+	tokenVerifier utils.TokenVerifier
+	descriptors   []utils.SinglePurposeTokenDescriptor
+}
+
+// NewIntrospectionRoute initializes a new IntrospectionRoute. Authentication tokens are always introspected, single
+// purpose tokens only if their descriptor is given.
+func NewIntrospectionRoute(path string, tokenVerifier utils.TokenVerifier, descriptors ...utils.SinglePurposeTokenDescriptor) *IntrospectionRoute {
+	return &IntrospectionRoute{
+		AuthenticationMixin: NewRequireAuthenticationMixin(),
+		MethodAndPathMixin:  NewMethodAndPathMixin("POST", path),
+		AdvancedRouteMixin:  NewAdvancedRouteMixin(false, false),
+		RoutePolicyMixin:    NewRoutePolicyMixin(NewSystemPolicy()),
+		tokenVerifier:       tokenVerifier,
+		descriptors:         descriptors,
+	}
+}
+
+// Decoder implements the Route interface.
+func (i *IntrospectionRoute) Decoder(_ context.Context, r *http.Request) (interface{}, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, xerror.Wrap(err, ErrorBadRequest)
+	}
+	token := strings.TrimSpace(r.PostForm.Get(introspectionTokenParam))
+	if token == "" {
+		return nil, xerror.Wrap(xerror.New(ErrorMissingIntrospectedToken), ErrorBadRequest)
+	}
+	return token, nil
+}
+
+// Endpoint implements the Route interface.
+func (i *IntrospectionRoute) Endpoint(ctx context.Context, request interface{}) (interface{}, error) {
+	token := request.(string)
+
+	if claims, err := i.tokenVerifier.VerifyTokenClaims(token); err == nil {
+		return newIntrospectionResponse(claims, nil), nil
+	}
+	for _, descriptor := range i.descriptors {
+		if claims, customClaims, err := i.tokenVerifier.VerifySinglePurposeTokenClaims(token, descriptor); err == nil {
+			return newIntrospectionResponse(claims, customClaims), nil
+		}
+	}
+	return &IntrospectionResponse{Active: false}, nil
+}
+
+// Encoder implements the Route interface.
+func (i *IntrospectionRoute) Encoder(_ context.Context, w http.ResponseWriter, resp interface{}) error {
+	w.Header().Add(contentTypeHeaderName, jsonContentTypeHeaderValue)
+	w.Header().Set(cacheControlHeaderName, noStoreHeaderValue)
+	return json.NewEncoder(w).Encode(resp)
+}
+
+func newIntrospectionResponse(claims *utils.TokenClaims, customClaims map[string]interface{}) *IntrospectionResponse {
+	actorSub := ""
+	if claims.Actor != nil {
+		actorSub = fmt.Sprintf("%v", claims.Actor.Subject)
+	}
+	return &IntrospectionResponse{
+		Active:       true,
+		Sub:          fmt.Sprintf("%v", claims.Subject),
+		Role:         claims.Role,
+		Scope:        strings.Join(claims.Scopes, " "),
+		JTI:          claims.ID,
+		ActorSub:     actorSub,
+		Exp:          claims.ExpiresAt.Unix(),
+		Iat:          claims.IssuedAt.Unix(),
+		CustomClaims: customClaims,
+	}
+}
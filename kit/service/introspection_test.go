@@ -0,0 +1,88 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIntrospectionRoute(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	descriptor := utils.NewSinglePurposeTokenDescriptor("test-role", true, utils.DefaultSinglePurposeTokenLifetime, map[string]reflect.Type{"test": reflect.TypeOf("")})
+
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil)
+	router.MountRoute(NewIntrospectionRoute("/introspect", tv, descriptor))
+	ts := httptest.NewServer(router.GetMux())
+	defer ts.Close()
+
+	systemToken, err := ti.IssueAccessSystemToken()
+	assert.Nil(t, err)
+	userToken, err := ti.IssueScopedAccessUserToken(1, "messages:read")
+	assert.Nil(t, err)
+	singlePurposeToken, err := ti.IssueSinglePurposeToken(descriptor, 2, map[string]interface{}{"test": "v"})
+	assert.Nil(t, err)
+
+	introspect := func(authorization, token string) (int, map[string]interface{}) {
+		req, err := http.NewRequest("POST", ts.URL+"/v1/introspect", strings.NewReader(url.Values{"token": {token}}.Encode()))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if authorization != "" {
+			req.Header.Set("Authorization", "Bearer "+authorization)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		body := map[string]interface{}{}
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&body))
+		return res.StatusCode, body
+	}
+
+	status, body := introspect(systemToken, userToken)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, true, body["active"])
+	assert.Equal(t, "1", body["sub"])
+	assert.Equal(t, utils.TokenAccessUserRole, body["role"])
+	assert.Equal(t, "messages:read", body["scope"])
+	assert.NotNil(t, body["exp"])
+	assert.NotNil(t, body["iat"])
+	assert.NotNil(t, body["jti"])
+	assert.Nil(t, body["act"])
+
+	delegatedToken, err := ti.IssueDelegatedAccessUserToken(1, 3)
+	assert.Nil(t, err)
+	status, body = introspect(systemToken, delegatedToken)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "1", body["sub"])
+	assert.Equal(t, map[string]interface{}{"sub": "3"}, body["act"])
+
+	status, body = introspect(systemToken, singlePurposeToken)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, true, body["active"])
+	assert.Equal(t, "2", body["sub"])
+	assert.Equal(t, "test-role", body["role"])
+	assert.Equal(t, "v", body["test"])
+
+	status, body = introspect(systemToken, "bad")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{"active": false}, body)
+
+	status, body = introspect(systemToken, "")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, "bad request: missing introspected token", body["error"])
+
+	status, _ = introspect(userToken, userToken)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	status, _ = introspect("", userToken)
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
@@ -16,6 +16,9 @@ const (
 	durationKey = "durationUs"
 	requestKey  = "req"
 	errorKey    = "err"
+	subKey      = "sub"
+	actorKey    = "actor"
+	apiKeyKey   = "apiKey"
 )
 
 // NewRootLogger creates a root logger and configures the standard go log library.
@@ -35,7 +38,8 @@ func NewBackgroundLogger(rootLogger kitlog.Logger) kitlog.Logger {
 	return kitlog.NewContext(rootLogger).With("background", true)
 }
 
-// NewLoggingMiddleware creates a new standard logging middleware for a Go microservice.
+// NewLoggingMiddleware creates a new standard logging middleware for a Go microservice. It logs the verified identity
+// found in the request context, which the Router sets up before running the middlewares.
 func NewLoggingMiddleware(logger kitlog.Logger) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (resp interface{}, err error) {
@@ -48,23 +52,28 @@ func NewLoggingMiddleware(logger kitlog.Logger) endpoint.Middleware {
 }
 
 func logRequest(logger kitlog.Logger, ctx context.Context, startTime time.Time, req interface{}, err error) {
-	if err == nil {
-		logger.Log(
-			actionKey, ctxRequestPath(ctx),
-			durationKey, durationUs(startTime),
-			ctxLabelTraceID, CtxTraceID(ctx),
-			ctxLabelClientType, ctxClientType(ctx),
-			ctxLabelClientVersion, ctxClientVersion(ctx))
-		return
-	}
-	logger.Log(
+	keyvals := []interface{}{
 		actionKey, ctxRequestPath(ctx),
 		durationKey, durationUs(startTime),
 		ctxLabelTraceID, CtxTraceID(ctx),
 		ctxLabelClientType, ctxClientType(ctx),
 		ctxLabelClientVersion, ctxClientVersion(ctx),
-		requestKey, strings.Split(spew.Sdump(req), "\n"),
-		errorKey, err)
+	}
+	if role := ctxAuthorizedRole(ctx); role != "" && role != APIKeyRole {
+		keyvals = append(keyvals, subKey, ctxAuthorizedSub(ctx))
+	}
+	if actorSub, isDelegated := CtxAuthorizedActor(ctx); isDelegated {
+		keyvals = append(keyvals, actorKey, actorSub)
+	}
+	if apiKey := ctxAuthorizedAPIKey(ctx); apiKey != nil {
+		keyvals = append(keyvals, apiKeyKey, apiKey.ID)
+	}
+	if err != nil {
+		keyvals = append(keyvals,
+			requestKey, strings.Split(spew.Sdump(req), "\n"),
+			errorKey, err)
+	}
+	logger.Log(keyvals...)
 }
 
 func durationUs(startTime time.Time) int64 {
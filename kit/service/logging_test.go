@@ -34,4 +34,32 @@ func TestLogging(t *testing.T) {
 	assert.Equal(t, "client-version", parsedLogEntry[ctxLabelClientVersion])
 	assert.NotNil(t, parsedLogEntry[requestKey])
 	assert.NotNil(t, parsedLogEntry[errorKey])
+	assert.Nil(t, parsedLogEntry[subKey])
+	assert.Nil(t, parsedLogEntry[actorKey])
+}
+
+func TestLoggingDelegatedToken(t *testing.T) {
+	w := bytes.NewBufferString("")
+
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	token, err := ti.IssueDelegatedAccessUserToken(1, 2)
+	assert.Nil(t, err)
+
+	authenticator := newAuthenticator(func(ctx context.Context) (context.Context, error) {
+		return requireToken(ctx, tv)
+	})
+	ctx := authenticator(ctxWithToken(context.Background(), token), nil)
+
+	logger := utils.NewFormattedJSONLogger(w)
+	loggingFunc := NewLoggingMiddleware(logger)(NewTokenMiddleware(tv)(test.TerminationMiddleware))
+	_, err = loggingFunc(ctx, test.MustNewRequest())
+	assert.Equal(t, "terminated", err.Error())
+
+	parsedLogEntry := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(w.Bytes(), &parsedLogEntry))
+	assert.EqualValues(t, 1, parsedLogEntry[subKey])
+	assert.EqualValues(t, 2, parsedLogEntry[actorKey])
 }
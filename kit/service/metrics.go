@@ -1,6 +1,7 @@
 package service
 
 import (
+	"github.com/ConnectCorp/go-kit/kit/utils"
 	"github.com/go-kit/kit/endpoint"
 	kitmetrics "github.com/go-kit/kit/metrics"
 	kitdogstatsd "github.com/go-kit/kit/metrics/dogstatsd"
@@ -16,6 +17,8 @@ const (
 	requestDurationLabel   = "request_duration_ms"
 	requestCounterLabel    = "request_counter"
 	errorCounterLabel      = "error_counter"
+	tokenCacheHitLabel     = "token_cache_hit_counter"
+	tokenCacheMissLabel    = "token_cache_miss_counter"
 )
 
 // MetricsReporter is an interface that allows to report standard metrics for a request.
@@ -105,6 +108,46 @@ func makeErrorCounterMetric(namespace, system, label string, dogstatsdEmitter *k
 	return kitmetrics.NewMultiCounter(label, counters...)
 }
 
+type tokenCacheReporter struct {
+	hitCounterMetric  kitmetrics.Counter
+	missCounterMetric kitmetrics.Counter
+}
+
+// ReportTokenCacheLookup implements the utils.TokenCacheReporter interface.
+func (r *tokenCacheReporter) ReportTokenCacheLookup(isHit bool) {
+	if isHit {
+		r.hitCounterMetric.Add(1)
+	} else {
+		r.missCounterMetric.Add(1)
+	}
+}
+
+// NewTokenCacheReporter creates a new utils.TokenCacheReporter that targets the same backends as NewMetricsReporter.
+func NewTokenCacheReporter(namespace, system string, dogstatsdEmitter *kitdogstatsd.Emitter) utils.TokenCacheReporter {
+	return &tokenCacheReporter{
+		hitCounterMetric:  makeTokenCacheCounterMetric(namespace, system, tokenCacheHitLabel, "Total number of token cache hits.", dogstatsdEmitter),
+		missCounterMetric: makeTokenCacheCounterMetric(namespace, system, tokenCacheMissLabel, "Total number of token cache misses.", dogstatsdEmitter),
+	}
+}
+
+func makeTokenCacheCounterMetric(namespace, system, label, help string, dogstatsdEmitter *kitdogstatsd.Emitter) kitmetrics.Counter {
+	counters := []kitmetrics.Counter{
+		kitexpvar.NewCounter(label),
+		kitprometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: system,
+			Name:      label,
+			Help:      help,
+		}, []string{}),
+	}
+
+	if dogstatsdEmitter != nil {
+		counters = append(counters, dogstatsdEmitter.NewCounter(label))
+	}
+
+	return kitmetrics.NewMultiCounter(label, counters...)
+}
+
 // NewMetricsMiddleware creates a new standard metrics middleware for a Go microservice.
 func NewMetricsMiddleware(metricsReporter MetricsReporter) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
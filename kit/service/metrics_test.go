@@ -83,6 +83,36 @@ func TestMetrics(t *testing.T) {
 	assert.Contains(t, dogstatsdMetrics, "test_error_counter:1|c|#action:test2")
 }
 
+func TestTokenCacheMetrics(t *testing.T) {
+	prometheusServer := httptest.NewServer(prometheus.Handler())
+	defer prometheusServer.Close()
+
+	dogstatsdBuffer := &syncbuf{buf: &bytes.Buffer{}}
+	dogstatsdEmitter := kitdogstatsd.NewEmitterDial(mockDialer(dogstatsdBuffer), "", "", "test_", time.Millisecond, log.NewNopLogger())
+
+	r := NewTokenCacheReporter("ns", "sys", dogstatsdEmitter)
+	r.ReportTokenCacheLookup(true)
+	r.ReportTokenCacheLookup(true)
+	r.ReportTokenCacheLookup(false)
+
+	// Verify Prometheus metrics.
+	resp, err := http.Get(prometheusServer.URL)
+	assert.Nil(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, resp.Body.Close())
+	prometheusMetrics := parsePrometheus(string(body))
+	assertMetric(t, prometheusMetrics, "ns_sys_token_cache_hit_counter", "2")
+	assertMetric(t, prometheusMetrics, "ns_sys_token_cache_miss_counter", "1")
+
+	// Ensure that dogstatsd metrics are emitted.
+	time.Sleep(2 * time.Millisecond)
+
+	// Verify dogstatsd metrics.
+	dogstatsdMetrics := strings.Split(dogstatsdBuffer.String(), "\n")
+	assert.Contains(t, dogstatsdMetrics, "test_token_cache_hit_counter:1|c")
+	assert.Contains(t, dogstatsdMetrics, "test_token_cache_miss_counter:1|c")
+}
+
 func parsePrometheus(body string) map[string]string {
 	metrics := make(map[string]string)
 	for _, line := range strings.Split(body, "\n") {
@@ -1,12 +1,14 @@
 package service
 
 import (
+	"github.com/gorilla/mux"
 	"golang.org/x/net/context"
 	"net/http"
 )
 
 const (
 	ctxLabelRequestPath = "requestPath"
+	ctxLabelRequestVars = "requestVars"
 )
 
 // RequestPathExtractor is a go-kit before interceptor that puts the request path in the request context.
@@ -21,3 +23,20 @@ func ctxWithRequestPath(ctx context.Context, path string) context.Context {
 func ctxRequestPath(ctx context.Context) string {
 	return EnsureString(ctx, ctxLabelRequestPath)
 }
+
+// RequestVarsExtractor is a go-kit before interceptor that puts the route variables of the request in the request context.
+func RequestVarsExtractor(ctx context.Context, r *http.Request) context.Context {
+	return ctxWithRequestVars(ctx, mux.Vars(r))
+}
+
+func ctxWithRequestVars(ctx context.Context, vars map[string]string) context.Context {
+	return context.WithValue(ctx, ctxLabelRequestVars, vars)
+}
+
+// CtxRequestVar extracts a route variable of the request from the context, returns "" if not found.
+func CtxRequestVar(ctx context.Context, name string) string {
+	if v, ok := ctx.Value(ctxLabelRequestVars).(map[string]string); ok {
+		return v[name]
+	}
+	return ""
+}
@@ -1,9 +1,11 @@
 package service
 
 import (
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -12,3 +14,15 @@ func TestRequestPathExtractor(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "/path", ctxRequestPath(RequestPathExtractor(context.Background(), req)))
 }
+
+func TestRequestVarsExtractor(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://url/users/1", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "", CtxRequestVar(RequestVarsExtractor(context.Background(), req), "id"))
+
+	router := mux.NewRouter()
+	router.Path("/users/{id}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "1", CtxRequestVar(RequestVarsExtractor(context.Background(), r), "id"))
+	})
+	router.ServeHTTP(httptest.NewRecorder(), req)
+}
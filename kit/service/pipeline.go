@@ -0,0 +1,296 @@
+package service
+
+import (
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+)
+
+const (
+	// ErrorDuplicateMiddleware is used when registering a middleware with a name already used in the Router pipeline.
+	ErrorDuplicateMiddleware = "duplicate middleware: %v"
+	// ErrorUnknownMiddleware is used when referring to a middleware that is not in the Router pipeline.
+	ErrorUnknownMiddleware = "unknown middleware: %v"
+	// ErrorMissingRequiredMiddleware is used when mounting a route that authenticates requests on a Router pipeline
+	// without the authentication or policy middleware it requires.
+	ErrorMissingRequiredMiddleware = "missing required middleware: %v for %v %v"
+)
+
+// Names of the built-in middlewares of the Router pipeline, in their default order, from outermost to innermost.
+const (
+	MiddlewareLogging        = "logging"
+	MiddlewareAudit          = "audit"
+	MiddlewareWire           = "wire"
+	MiddlewareAuthentication = "authentication"
+	MiddlewarePolicy         = "policy"
+	MiddlewareValidation     = "validation"
+)
+
+// RouteMiddlewareFactory makes the middleware of a pipeline entry for the given route when it is mounted, or returns
+// nil to skip the entry for the route.
+type RouteMiddlewareFactory func(route Route) endpoint.Middleware
+
+// NewStaticMiddlewareFactory initializes a new RouteMiddlewareFactory that uses the given middleware for all routes.
+func NewStaticMiddlewareFactory(middleware endpoint.Middleware) RouteMiddlewareFactory {
+	return func(_ Route) endpoint.Middleware {
+		return middleware
+	}
+}
+
+// pipelineEntry is a named middleware of the Router pipeline. Built-in entries can also make a go-kit before handler,
+// which runs after all the request extractors.
+type pipelineEntry struct {
+	name             string
+	factory          RouteMiddlewareFactory
+	extractorFactory func(route Route) kithttp.RequestFunc
+}
+
+// PipelineRoute is implemented by routes that extend the Router pipeline. Their middlewares run inside the Router
+// middlewares, from first to last; their request extractors and response finalizers run after the Router ones. Request
+// extractors run before the request credentials are verified, so they can supply them.
+type PipelineRoute interface {
+	GetMiddlewares() []endpoint.Middleware
+	GetRequestExtractors() []kithttp.RequestFunc
+	GetResponseFinalizers() []kithttp.ResponseFunc
+}
+
+// PipelineMixin is a mixin implementing the PipelineRoute interface.
+type PipelineMixin struct {
+	middlewares []endpoint.Middleware
+	extractors  []kithttp.RequestFunc
+	finalizers  []kithttp.ResponseFunc
+}
+
+// NewPipelineMixin initializes a new PipelineMixin. Any argument can be nil.
+func NewPipelineMixin(middlewares []endpoint.Middleware, extractors []kithttp.RequestFunc, finalizers []kithttp.ResponseFunc) PipelineMixin {
+	return PipelineMixin{middlewares: middlewares, extractors: extractors, finalizers: finalizers}
+}
+
+// GetMiddlewares implements the PipelineRoute interface.
+func (m *PipelineMixin) GetMiddlewares() []endpoint.Middleware {
+	return m.middlewares
+}
+
+// GetRequestExtractors implements the PipelineRoute interface.
+func (m *PipelineMixin) GetRequestExtractors() []kithttp.RequestFunc {
+	return m.extractors
+}
+
+// GetResponseFinalizers implements the PipelineRoute interface.
+func (m *PipelineMixin) GetResponseFinalizers() []kithttp.ResponseFunc {
+	return m.finalizers
+}
+
+// UseMiddleware adds a named middleware to the Router pipeline, inside all the other ones, i.e. right before the
+// endpoint runs. It applies to routes mounted afterwards, and panics if the name is already used.
+func (r *Router) UseMiddleware(name string, factory RouteMiddlewareFactory) *Router {
+	return r.insertMiddleware(len(r.middlewares), name, factory)
+}
+
+// UseMiddlewareBefore adds a named middleware to the Router pipeline, right outside the referenced one, so that it
+// runs before it. It panics if the reference is unknown or the name is already used.
+func (r *Router) UseMiddlewareBefore(ref, name string, factory RouteMiddlewareFactory) *Router {
+	return r.insertMiddleware(r.mustGetMiddlewareIndex(ref), name, factory)
+}
+
+// UseMiddlewareAfter adds a named middleware to the Router pipeline, right inside the referenced one, so that it runs
+// after it. It panics if the reference is unknown or the name is already used.
+func (r *Router) UseMiddlewareAfter(ref, name string, factory RouteMiddlewareFactory) *Router {
+	return r.insertMiddleware(r.mustGetMiddlewareIndex(ref)+1, name, factory)
+}
+
+// ReplaceMiddleware replaces a named middleware of the Router pipeline, including built-in ones, keeping its position.
+// It panics if the name is unknown. Replacing the authentication middleware also drops the verification of the request
+// credentials before the pipeline, so that only the replacement authenticates requests. Mounting a route that
+// authenticates requests panics if the replacement of the authentication or policy middleware skips it, so that
+// authorization cannot be disabled by mistake.
+func (r *Router) ReplaceMiddleware(name string, factory RouteMiddlewareFactory) *Router {
+	r.middlewares[r.mustGetMiddlewareIndex(name)] = &pipelineEntry{name: name, factory: factory}
+	return r
+}
+
+// RemoveMiddleware removes a named middleware from the Router pipeline, including built-in ones. It panics if the name
+// is unknown. Mounting a route that authenticates requests panics if the authentication or policy middleware it
+// requires has been removed.
+func (r *Router) RemoveMiddleware(name string) *Router {
+	i := r.mustGetMiddlewareIndex(name)
+	r.middlewares = append(r.middlewares[:i], r.middlewares[i+1:]...)
+	return r
+}
+
+// GetMiddlewareNames returns the names of the middlewares in the Router pipeline, from outermost to innermost.
+func (r *Router) GetMiddlewareNames() []string {
+	names := make([]string, 0, len(r.middlewares))
+	for _, entry := range r.middlewares {
+		names = append(names, entry.name)
+	}
+	return names
+}
+
+// UseRequestExtractors adds go-kit before handlers to routes mounted afterwards. They run after the built-in ones, so
+// they can use the request context set up by them, e.g. CtxTraceID, and before the request credentials are verified, so
+// they can supply them, e.g. a token from a custom header.
+func (r *Router) UseRequestExtractors(extractors ...kithttp.RequestFunc) *Router {
+	r.extractors = append(r.extractors, extractors...)
+	return r
+}
+
+// UseResponseFinalizers adds go-kit after handlers to routes mounted afterwards. They run after the built-in ones, once
+// the endpoint has succeeded and before the response is encoded.
+func (r *Router) UseResponseFinalizers(finalizers ...kithttp.ResponseFunc) *Router {
+	r.finalizers = append(r.finalizers, finalizers...)
+	return r
+}
+
+// makePipelineExtractors makes the before handlers of the Router pipeline entries for the route.
+func (r *Router) makePipelineExtractors(route Route) []kithttp.RequestFunc {
+	extractors := make([]kithttp.RequestFunc, 0, len(r.middlewares))
+	for _, entry := range r.middlewares {
+		if entry.extractorFactory == nil {
+			continue
+		}
+		if extractor := entry.extractorFactory(route); extractor != nil {
+			extractors = append(extractors, extractor)
+		}
+	}
+	return extractors
+}
+
+func (r *Router) insertMiddleware(i int, name string, factory RouteMiddlewareFactory) *Router {
+	if r.getMiddlewareIndex(name) >= 0 {
+		panic(xerror.New(ErrorDuplicateMiddleware, name))
+	}
+	r.middlewares = append(r.middlewares, nil)
+	copy(r.middlewares[i+1:], r.middlewares[i:])
+	r.middlewares[i] = &pipelineEntry{name: name, factory: factory}
+	return r
+}
+
+func (r *Router) getMiddlewareIndex(name string) int {
+	for i, entry := range r.middlewares {
+		if entry.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *Router) mustGetMiddlewareIndex(name string) int {
+	i := r.getMiddlewareIndex(name)
+	if i < 0 {
+		panic(xerror.New(ErrorUnknownMiddleware, name))
+	}
+	return i
+}
+
+// getRequiredMiddlewareNames returns the names of the built-in middlewares that must not be skipped for the route.
+func getRequiredMiddlewareNames(route Route) []string {
+	if _, ok := getSinglePurposeTokenRoute(route); ok || isAuthenticationOptional(route) {
+		return []string{MiddlewareAuthentication}
+	}
+	if route.IsAuthenticated() {
+		return []string{MiddlewareAuthentication, MiddlewarePolicy}
+	}
+	return nil
+}
+
+func (r *Router) makeBuiltInMiddlewares() []*pipelineEntry {
+	return []*pipelineEntry{
+		{name: MiddlewareLogging, factory: r.makeLoggingMiddleware},
+		{name: MiddlewareAudit, factory: r.makeAuditMiddleware},
+		{name: MiddlewareWire, factory: r.makeWireMiddleware},
+		{name: MiddlewareAuthentication, factory: r.makeAuthenticationMiddleware, extractorFactory: r.makeAuthenticator},
+		{name: MiddlewarePolicy, factory: r.makePolicyMiddleware},
+		{name: MiddlewareValidation, factory: r.makeValidationMiddleware},
+	}
+}
+
+func (r *Router) makeLoggingMiddleware(_ Route) endpoint.Middleware {
+	return NewLoggingMiddleware(r.transportLogger)
+}
+
+func (r *Router) makeAuditMiddleware(route Route) endpoint.Middleware {
+	if r.auditSink == nil {
+		return nil
+	}
+	return NewAuditMiddleware(r.auditSink, route.GetMethod()+" "+route.GetPath(), r.transportLogger)
+}
+
+func (r *Router) makeWireMiddleware(route Route) endpoint.Middleware {
+	if advancedRoute, ok := route.(AdvancedRoute); ok && !advancedRoute.EnableWireMiddleware() {
+		return nil
+	}
+	return NewWireMiddleware()
+}
+
+func (r *Router) makeAuthenticationMiddleware(route Route) endpoint.Middleware {
+	if singlePurposeTokenRoute, ok := getSinglePurposeTokenRoute(route); ok {
+		return NewSinglePurposeTokenMiddleware(r.tokenVerifier, singlePurposeTokenRoute.GetSinglePurposeTokenDescriptor())
+	}
+	if isAuthenticationOptional(route) && r.apiKeyStore != nil {
+		return NewOptionalTokenOrAPIKeyMiddleware(r.tokenVerifier, r.apiKeyStore)
+	}
+	if isAuthenticationOptional(route) {
+		return NewOptionalTokenMiddleware(r.tokenVerifier)
+	}
+	if route.IsAuthenticated() && r.apiKeyStore != nil {
+		return NewTokenOrAPIKeyMiddleware(r.tokenVerifier, r.apiKeyStore)
+	}
+	if route.IsAuthenticated() {
+		return NewTokenMiddleware(r.tokenVerifier)
+	}
+	return NewNoTokenMiddleware()
+}
+
+// makeAuthenticator makes the before handler of the authentication entry, verifying the credentials expected by its
+// middleware for the route, so that their claims are in the context of the whole pipeline, or returns nil if the route
+// has none.
+func (r *Router) makeAuthenticator(route Route) kithttp.RequestFunc {
+	if singlePurposeTokenRoute, ok := getSinglePurposeTokenRoute(route); ok {
+		descriptor := singlePurposeTokenRoute.GetSinglePurposeTokenDescriptor()
+		return newAuthenticator(func(ctx context.Context) (context.Context, error) {
+			return requireSinglePurposeToken(ctx, r.tokenVerifier, descriptor)
+		})
+	}
+	if isAuthenticationOptional(route) && r.apiKeyStore != nil {
+		return newAuthenticator(func(ctx context.Context) (context.Context, error) {
+			return checkTokenOrAPIKey(ctx, r.tokenVerifier, r.apiKeyStore)
+		})
+	}
+	if isAuthenticationOptional(route) {
+		return newAuthenticator(func(ctx context.Context) (context.Context, error) {
+			return checkToken(ctx, r.tokenVerifier)
+		})
+	}
+	if route.IsAuthenticated() && r.apiKeyStore != nil {
+		return newAuthenticator(func(ctx context.Context) (context.Context, error) {
+			return requireTokenOrAPIKey(ctx, r.tokenVerifier, r.apiKeyStore)
+		})
+	}
+	if route.IsAuthenticated() {
+		return newAuthenticator(func(ctx context.Context) (context.Context, error) {
+			return requireToken(ctx, r.tokenVerifier)
+		})
+	}
+	return nil
+}
+
+func (r *Router) makePolicyMiddleware(route Route) endpoint.Middleware {
+	if _, ok := getSinglePurposeTokenRoute(route); ok || isAuthenticationOptional(route) || !route.IsAuthenticated() {
+		return nil
+	}
+	return NewPolicyMiddleware(mustGetRoutePolicy(route))
+}
+
+func (r *Router) makeValidationMiddleware(route Route) endpoint.Middleware {
+	if !isRequestValidated(route) {
+		return nil
+	}
+	return NewValidationMiddleware()
+}
+
+func isAuthenticationOptional(route Route) bool {
+	optionalAuthentication, ok := route.(OptionalAuthentication)
+	return ok && optionalAuthentication.IsAuthenticationOptional()
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+type testPipelineRoute struct {
+	testRoute
+	PipelineMixin
+}
+
+func newTestPipelineRoute(path string, pipeline PipelineMixin) *testPipelineRoute {
+	return &testPipelineRoute{
+		testRoute: testRoute{
+			AuthenticationMixin: NewRejectAuthenticationMixin(),
+			MethodAndPathMixin:  NewMethodAndPathMixin("GET", path),
+			AdvancedRouteMixin:  NewAdvancedRouteMixin(false, false),
+		},
+		PipelineMixin: pipeline,
+	}
+}
+
+func makeTestRecordingMiddleware(calls *[]string, name string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			*calls = append(*calls, name)
+			return next(ctx, request)
+		}
+	}
+}
+
+func TestRouterPipeline(t *testing.T) {
+	calls := make([]string, 0)
+	factory := func(name string) RouteMiddlewareFactory {
+		return NewStaticMiddlewareFactory(makeTestRecordingMiddleware(&calls, name))
+	}
+
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), nil, nil, nil, nil)
+	assert.Equal(t, []string{MiddlewareLogging, MiddlewareAudit, MiddlewareWire, MiddlewareAuthentication, MiddlewarePolicy, MiddlewareValidation}, router.GetMiddlewareNames())
+
+	router.
+		UseMiddleware("inner", factory("inner")).
+		UseMiddlewareBefore(MiddlewareLogging, "outer", factory("outer")).
+		UseMiddlewareAfter(MiddlewareWire, "afterWire", factory("afterWire")).
+		UseMiddleware("skipped", func(_ Route) endpoint.Middleware { return nil }).
+		RemoveMiddleware(MiddlewareAudit).
+		ReplaceMiddleware(MiddlewarePolicy, factory("policy"))
+	assert.Equal(t, []string{"outer", MiddlewareLogging, MiddlewareWire, "afterWire", MiddlewareAuthentication, MiddlewarePolicy, MiddlewareValidation, "inner", "skipped"}, router.GetMiddlewareNames())
+
+	assert.Panics(t, func() { router.UseMiddleware("inner", factory("inner")) })
+	assert.Panics(t, func() { router.UseMiddlewareBefore("unknown", "other", factory("other")) })
+	assert.Panics(t, func() { router.RemoveMiddleware(MiddlewareAudit) })
+
+	routeMiddlewares := []endpoint.Middleware{makeTestRecordingMiddleware(&calls, "route1"), makeTestRecordingMiddleware(&calls, "route2")}
+	router.MountRoute(newTestPipelineRoute("/pipeline", NewPipelineMixin(routeMiddlewares, nil, nil)))
+	router.MountRoute(newTestPipelineRoute("/plain", NewPipelineMixin(nil, nil, nil)))
+
+	_, err := router.getEndpointWithMiddlewares(newTestPipelineRoute("/pipeline", NewPipelineMixin(routeMiddlewares, nil, nil)))(context.Background(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"outer", "afterWire", "policy", "inner", "route1", "route2"}, calls)
+}
+
+func TestRouterRequiresAuthorizationMiddlewares(t *testing.T) {
+	skip := func(_ Route) endpoint.Middleware { return nil }
+	publicRoute := newTestPipelineRoute("/public", NewPipelineMixin(nil, nil, nil))
+	optionalRoute := newTestRoute(false)
+	optionalRoute.AuthenticationMixin = NewOptionalAuthenticationMixin()
+
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), nil, nil, nil, nil).RemoveMiddleware(MiddlewarePolicy)
+	assert.Panics(t, func() { router.MountRoute(newTestPolicyRoute("/user", NewUserPolicy())) })
+	assert.NotPanics(t, func() { router.MountRoute(optionalRoute) })
+	assert.NotPanics(t, func() { router.MountRoute(publicRoute) })
+
+	router = NewRouter("test", "/v1", NewRootLogger(os.Stdout), nil, nil, nil, nil).ReplaceMiddleware(MiddlewareAuthentication, skip)
+	assert.Panics(t, func() { router.MountRoute(newTestPolicyRoute("/user", NewUserPolicy())) })
+	assert.Panics(t, func() { router.MountRoute(optionalRoute) })
+	assert.NotPanics(t, func() { router.MountRoute(publicRoute) })
+
+	router = NewRouter("test", "/v1", NewRootLogger(os.Stdout), nil, nil, nil, nil).ReplaceMiddleware(MiddlewarePolicy, skip)
+	assert.Panics(t, func() { router.MountRoute(newTestPolicyRoute("/user", NewUserPolicy())) })
+	router.ReplaceMiddleware(MiddlewarePolicy, NewStaticMiddlewareFactory(NewPolicyMiddleware(NewUserPolicy())))
+	assert.NotPanics(t, func() { router.MountRoute(newTestPolicyRoute("/user", NewUserPolicy())) })
+}
+
+func TestRouterPipelineHooks(t *testing.T) {
+	const ctxLabelTest = "test"
+	calls := make([]string, 0)
+	extractor := func(name string) kithttp.RequestFunc {
+		return func(ctx context.Context, r *http.Request) context.Context {
+			calls = append(calls, name)
+			assert.NotEqual(t, "", CtxTraceID(ctx))
+			return context.WithValue(ctx, ctxLabelTest, r.Header.Get("X-Test"))
+		}
+	}
+	finalizer := func(name string) kithttp.ResponseFunc {
+		return func(ctx context.Context, w http.ResponseWriter) {
+			calls = append(calls, name)
+			w.Header().Set("X-"+name, EnsureString(ctx, ctxLabelTest))
+		}
+	}
+
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), nil, nil, nil, nil).
+		UseRequestExtractors(extractor("router")).
+		UseResponseFinalizers(finalizer("Router"))
+	router.MountRoute(newTestPipelineRoute("/hooks", NewPipelineMixin(
+		nil, []kithttp.RequestFunc{extractor("route")}, []kithttp.ResponseFunc{finalizer("Route")})))
+	ts := httptest.NewServer(router.GetMux())
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL+"/v1/hooks", nil)
+	assert.Nil(t, err)
+	req.Header.Set("X-Test", "value")
+	res, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Nil(t, res.Body.Close())
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "value", res.Header.Get("X-Router"))
+	assert.Equal(t, "value", res.Header.Get("X-Route"))
+	assert.NotEqual(t, "", res.Header.Get(traceIDHeader))
+	assert.Equal(t, []string{"router", "route", "Router", "Route"}, calls)
+}
+
+func TestRouterAuthenticationExtractor(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	token, err := ti.IssueAccessUserToken(1)
+	assert.Nil(t, err)
+
+	get := func(router *Router, header string) int {
+		ts := httptest.NewServer(router.GetMux())
+		defer ts.Close()
+		req, err := http.NewRequest("GET", ts.URL+"/v1/user", nil)
+		assert.Nil(t, err)
+		req.Header.Set(header, "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Nil(t, res.Body.Close())
+		return res.StatusCode
+	}
+
+	// Request extractors run before the credentials are verified, so they can supply them to the whole pipeline.
+	var sub int64
+	outer := func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			sub = ctxAuthorizedSub(ctx)
+			return next(ctx, request)
+		}
+	}
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil).
+		UseMiddlewareBefore(MiddlewareLogging, "outer", NewStaticMiddlewareFactory(outer)).
+		UseRequestExtractors(func(ctx context.Context, r *http.Request) context.Context {
+			if token := r.Header.Get("X-Test-Token"); token != "" {
+				return ctxWithToken(ctx, token)
+			}
+			return ctx
+		})
+	router.MountRoute(newTestPolicyRoute("/user", NewUserPolicy()))
+	assert.Equal(t, http.StatusOK, get(router, "X-Test-Token"))
+	assert.EqualValues(t, 1, sub)
+
+	// A replaced authentication middleware is the only one authenticating requests.
+	var isVerified bool
+	replacement := func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			_, isVerified = ctxAuthentication(ctx)
+			return next(ctxWithAuthorizedRole(ctxWithAuthorizedSub(ctx, 2), utils.TokenAccessUserRole), request)
+		}
+	}
+	router = NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil).
+		ReplaceMiddleware(MiddlewareAuthentication, NewStaticMiddlewareFactory(replacement))
+	router.MountRoute(newTestPolicyRoute("/user", NewUserPolicy()))
+	assert.Equal(t, http.StatusOK, get(router, authorizationHeader))
+	assert.False(t, isVerified)
+}
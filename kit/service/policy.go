@@ -0,0 +1,166 @@
+package service
+
+import (
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"strconv"
+)
+
+const (
+	// ErrorMissingRoutePolicy is used when mounting an authenticated route that does not declare a RoutePolicy.
+	ErrorMissingRoutePolicy = "missing route policy: %v %v"
+	// ErrorInvalidPolicyRole is used when initializing a RoutePolicy with a role that cannot be authorized.
+	ErrorInvalidPolicyRole = "invalid policy role: %v"
+	// ErrorInvalidPathVar is returned when a path variable required by a RoutePolicy is missing or invalid.
+	ErrorInvalidPathVar = "invalid path var: %v"
+)
+
+// RoutePolicy describes the authorization policy of an authenticated route. It is enforced by the Router after the
+// token has been verified, and before the endpoint runs.
+type RoutePolicy interface {
+	Authorize(ctx context.Context) error
+}
+
+// PolicyRoute is implemented by routes that declare a RoutePolicy. Authenticated routes must implement it.
+type PolicyRoute interface {
+	GetRoutePolicy() RoutePolicy
+}
+
+// RoutePolicyMixin is a mixin implementing the PolicyRoute interface.
+type RoutePolicyMixin struct {
+	policy RoutePolicy
+}
+
+// NewRoutePolicyMixin initializes a new RoutePolicyMixin.
+func NewRoutePolicyMixin(policy RoutePolicy) RoutePolicyMixin {
+	return RoutePolicyMixin{policy: policy}
+}
+
+// GetRoutePolicy implements the PolicyRoute interface.
+func (m *RoutePolicyMixin) GetRoutePolicy() RoutePolicy {
+	return m.policy
+}
+
+// RoutePolicyFunc is a function implementing the RoutePolicy interface.
+type RoutePolicyFunc func(ctx context.Context) error
+
+// Authorize implements the RoutePolicy interface.
+func (f RoutePolicyFunc) Authorize(ctx context.Context) error {
+	return f(ctx)
+}
+
+// NewAuthVerifierPolicy initializes a new RoutePolicy that configures and runs an AuthVerifier for each request.
+func NewAuthVerifierPolicy(configure func(ctx context.Context, av AuthVerifier) AuthVerifier) RoutePolicy {
+	return RoutePolicyFunc(func(ctx context.Context) error {
+		return configure(ctx, NewContextAuthVerifier(ctx)).Verify()
+	})
+}
+
+// NewUserPolicy initializes a new RoutePolicy that accepts any access user token with the given scopes.
+func NewUserPolicy(scopes ...string) RoutePolicy {
+	return NewAuthVerifierPolicy(func(_ context.Context, av AuthVerifier) AuthVerifier {
+		return av.AcceptAnyAccessUserToken().RequireScopes(scopes...)
+	})
+}
+
+// NewOwnerPolicy initializes a new RoutePolicy that only accepts access user tokens with the given scopes, whose sub
+// matches the given path variable.
+func NewOwnerPolicy(pathVar string, scopes ...string) RoutePolicy {
+	return RoutePolicyFunc(func(ctx context.Context) error {
+		sub, err := strconv.ParseInt(CtxRequestVar(ctx, pathVar), 10, 64)
+		if err != nil {
+			return xerror.Wrap(xerror.New(ErrorInvalidPathVar, pathVar), ErrorBadRequest)
+		}
+		return NewContextAuthVerifier(ctx).AcceptAccessUserTokenForSubs(sub).RequireScopes(scopes...).Verify()
+	})
+}
+
+// NewSystemPolicy initializes a new RoutePolicy that only accepts access system tokens with the given scopes, including
+// service tokens.
+func NewSystemPolicy(scopes ...string) RoutePolicy {
+	return NewAuthVerifierPolicy(func(_ context.Context, av AuthVerifier) AuthVerifier {
+		return av.AcceptAccessSystemToken().RequireScopes(scopes...)
+	})
+}
+
+// NewServicePolicy initializes a new RoutePolicy that only accepts service tokens from the given clients.
+func NewServicePolicy(clients ...string) RoutePolicy {
+	return NewAuthVerifierPolicy(func(_ context.Context, av AuthVerifier) AuthVerifier {
+		return av.AcceptServiceTokenFromClients(clients...)
+	})
+}
+
+// NewAPIKeyPolicy initializes a new RoutePolicy that only accepts API keys with the given scopes. Routes using it must
+// be mounted on a Router with an APIKeyStore.
+func NewAPIKeyPolicy(scopes ...string) RoutePolicy {
+	return NewAuthVerifierPolicy(func(_ context.Context, av AuthVerifier) AuthVerifier {
+		return av.AcceptAnyAPIKey().RequireScopes(scopes...)
+	})
+}
+
+// MustNewRolesPolicy initializes a new RoutePolicy that accepts any token with one of the given roles, or panics if a
+// role is neither an access role nor APIKeyRole.
+func MustNewRolesPolicy(roles ...string) RoutePolicy {
+	acceptUser, acceptSystem, acceptAPIKey := false, false, false
+	for _, role := range roles {
+		switch role {
+		case utils.TokenAccessUserRole:
+			acceptUser = true
+		case utils.TokenAccessSystemRole:
+			acceptSystem = true
+		case APIKeyRole:
+			acceptAPIKey = true
+		default:
+			panic(xerror.New(ErrorInvalidPolicyRole, role))
+		}
+	}
+	return NewAuthVerifierPolicy(func(_ context.Context, av AuthVerifier) AuthVerifier {
+		if acceptUser {
+			av = av.AcceptAnyAccessUserToken()
+		}
+		if acceptSystem {
+			av = av.AcceptAccessSystemToken()
+		}
+		if acceptAPIKey {
+			av = av.AcceptAnyAPIKey()
+		}
+		return av
+	})
+}
+
+// NewAnyOfPolicy initializes a new RoutePolicy that authorizes requests authorized by any of the given policies. If
+// none does, the error of the last one is returned. A single decision is recorded by the AuditSink of the Router.
+func NewAnyOfPolicy(policies ...RoutePolicy) RoutePolicy {
+	return RoutePolicyFunc(func(ctx context.Context) error {
+		return auditOnce(ctx, func(ctx context.Context) error {
+			err := xerror.New(ErrorForbidden)
+			for _, policy := range policies {
+				if err = policy.Authorize(ctx); err == nil {
+					return nil
+				}
+			}
+			return err
+		})
+	})
+}
+
+// NewPolicyMiddleware enforces the given RoutePolicy. It must be wrapped by the token middleware.
+func NewPolicyMiddleware(policy RoutePolicy) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if err := policy.Authorize(ctx); err != nil {
+				return nil, err
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+func mustGetRoutePolicy(route Route) RoutePolicy {
+	if policyRoute, ok := route.(PolicyRoute); ok && policyRoute.GetRoutePolicy() != nil {
+		return policyRoute.GetRoutePolicy()
+	}
+	panic(xerror.New(ErrorMissingRoutePolicy, route.GetMethod(), route.GetPath()))
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+type testPolicyRoute struct {
+	testRoute
+}
+
+func (r *testPolicyRoute) Endpoint(ctx context.Context, request interface{}) (interface{}, error) {
+	return CtxRequestVar(ctx, "id"), nil
+}
+
+func newTestPolicyRoute(path string, policy RoutePolicy) *testPolicyRoute {
+	return &testPolicyRoute{testRoute: testRoute{
+		AuthenticationMixin: NewRequireAuthenticationMixin(),
+		MethodAndPathMixin:  NewMethodAndPathMixin("GET", path),
+		RoutePolicyMixin:    NewRoutePolicyMixin(policy),
+	}}
+}
+
+func makeTestPolicyCtx(role string, sub int64, client string, scopes ...string) context.Context {
+	ctx := ctxWithAuthorizedRole(ctxWithAuthorizedSub(context.Background(), sub), role)
+	ctx = ctxWithAuthorizedClient(ctxWithAuthorizedScopes(ctx, scopes), client)
+	return ctxWithRequestVars(ctx, map[string]string{"id": "1", "bad": "x"})
+}
+
+func TestRoutePolicies(t *testing.T) {
+	userCtx := makeTestPolicyCtx(utils.TokenAccessUserRole, 1, "", "messages:read")
+	otherUserCtx := makeTestPolicyCtx(utils.TokenAccessUserRole, 2, "")
+	systemCtx := makeTestPolicyCtx(utils.TokenAccessSystemRole, 0, "")
+	serviceCtx := makeTestPolicyCtx(utils.TokenAccessSystemRole, 0, "messages")
+
+	assertPolicy := func(policy RoutePolicy, ctx context.Context, isAuthorized bool) {
+		err := policy.Authorize(ctx)
+		if isAuthorized {
+			assert.Nil(t, err)
+		} else {
+			assert.True(t, xerror.Is(err, ErrorForbidden))
+		}
+	}
+
+	assertPolicy(NewUserPolicy(), userCtx, true)
+	assertPolicy(NewUserPolicy("messages:read"), userCtx, true)
+	assertPolicy(NewUserPolicy("messages:write"), userCtx, false)
+	assertPolicy(NewUserPolicy(), systemCtx, false)
+
+	assertPolicy(NewOwnerPolicy("id"), userCtx, true)
+	assertPolicy(NewOwnerPolicy("id", "messages:write"), userCtx, false)
+	assertPolicy(NewOwnerPolicy("id"), otherUserCtx, false)
+	assertPolicy(NewOwnerPolicy("id"), systemCtx, false)
+	err := NewOwnerPolicy("bad").Authorize(userCtx)
+	assert.True(t, xerror.Is(err, ErrorBadRequest))
+	assert.Equal(t, "bad request: invalid path var: bad", err.Error())
+
+	assertPolicy(NewSystemPolicy(), systemCtx, true)
+	assertPolicy(NewSystemPolicy(), serviceCtx, true)
+	assertPolicy(NewSystemPolicy(), userCtx, false)
+
+	assertPolicy(NewServicePolicy("messages"), serviceCtx, true)
+	assertPolicy(NewServicePolicy("notifications"), serviceCtx, false)
+	assertPolicy(NewServicePolicy("messages"), systemCtx, false)
+
+	assertPolicy(MustNewRolesPolicy(utils.TokenAccessUserRole, utils.TokenAccessSystemRole), userCtx, true)
+	assertPolicy(MustNewRolesPolicy(utils.TokenAccessUserRole, utils.TokenAccessSystemRole), systemCtx, true)
+	assertPolicy(MustNewRolesPolicy(utils.TokenAccessSystemRole), userCtx, false)
+	assert.Panics(t, func() { MustNewRolesPolicy(utils.TokenRefreshUserRole) })
+
+	assertPolicy(NewAnyOfPolicy(NewOwnerPolicy("id"), NewSystemPolicy()), userCtx, true)
+	assertPolicy(NewAnyOfPolicy(NewOwnerPolicy("id"), NewSystemPolicy()), systemCtx, true)
+	assertPolicy(NewAnyOfPolicy(NewOwnerPolicy("id"), NewSystemPolicy()), otherUserCtx, false)
+	assertPolicy(NewAnyOfPolicy(), userCtx, false)
+}
+
+func TestRouterEnforcesRoutePolicy(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil)
+	router.MountRoute(newTestPolicyRoute("/users/{id}", NewOwnerPolicy("id")))
+	ts := httptest.NewServer(router.GetMux())
+	defer ts.Close()
+
+	get := func(path string, sub int64) int {
+		token, err := ti.IssueAccessUserToken(sub)
+		assert.Nil(t, err)
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		assert.Nil(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Nil(t, res.Body.Close())
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, get("/v1/users/1", 1))
+	assert.Equal(t, http.StatusForbidden, get("/v1/users/1", 2))
+	assert.Equal(t, http.StatusBadRequest, get("/v1/users/x", 1))
+
+	assert.Panics(t, func() {
+		router.MountRoute(newTestPolicyRoute("/missing", nil))
+	})
+	assert.NotPanics(t, func() {
+		route := newTestPolicyRoute("/public", nil)
+		route.AuthenticationMixin = NewRejectAuthenticationMixin()
+		router.MountRoute(route)
+	})
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ErrorMissingServiceTokenTarget is returned when the service token exchange request does not name a target.
+	ErrorMissingServiceTokenTarget = "missing service token target"
+	// ErrorTargetNotAllowed is returned when the calling service is not allowed to call the requested target.
+	ErrorTargetNotAllowed = "target not allowed: %v"
+	// ErrorScopeNotAllowed is returned when the API key of the calling service does not grant a requested scope.
+	ErrorScopeNotAllowed = "scope not allowed: %v"
+)
+
+const (
+	bearerTokenType = "Bearer"
+)
+
+// serviceTokenRequest is the decoded request of the service token exchange route.
+type serviceTokenRequest struct {
+	target string
+	scopes []string
+}
+
+// ServiceTokenExchangeRoute is a Route exchanging the API key of a calling service for a service token to call a target
+// service. It is meant to be mounted by the auth service, the only one holding a TokenIssuer, on a Router with an
+// APIKeyStore: the owner of the API key is the client named in the token, and it can only request the scopes of its
+// key. It accepts form-encoded POST requests, as sent by utils.NewRemoteServiceTokenSource.
+type ServiceTokenExchangeRoute struct {
+	AuthenticationMixin
+	MethodAndPathMixin
+	JSONErrorEncoderMixin
+	AdvancedRouteMixin
+	RoutePolicyMixin
+	tokenIssuer utils.TokenIssuer
+	targets     map[string][]string
+}
+
+// NewServiceTokenExchangeRoute initializes a new ServiceTokenExchangeRoute. The targets map the owner of each API key
+// to the services it is allowed to call.
+func NewServiceTokenExchangeRoute(path string, tokenIssuer utils.TokenIssuer, targets map[string][]string) *ServiceTokenExchangeRoute {
+	return &ServiceTokenExchangeRoute{
+		AuthenticationMixin: NewRequireAuthenticationMixin(),
+		MethodAndPathMixin:  NewMethodAndPathMixin("POST", path),
+		AdvancedRouteMixin:  NewAdvancedRouteMixin(false, false),
+		RoutePolicyMixin:    NewRoutePolicyMixin(NewAPIKeyPolicy()),
+		tokenIssuer:         tokenIssuer,
+		targets:             targets,
+	}
+}
+
+// Decoder implements the Route interface.
+func (e *ServiceTokenExchangeRoute) Decoder(_ context.Context, r *http.Request) (interface{}, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, xerror.Wrap(err, ErrorBadRequest)
+	}
+	target := strings.TrimSpace(r.PostForm.Get(utils.ServiceTokenTargetParam))
+	if target == "" {
+		return nil, xerror.Wrap(xerror.New(ErrorMissingServiceTokenTarget), ErrorBadRequest)
+	}
+	return &serviceTokenRequest{target: target, scopes: strings.Fields(r.PostForm.Get(utils.ServiceTokenScopeParam))}, nil
+}
+
+// Endpoint implements the Route interface.
+func (e *ServiceTokenExchangeRoute) Endpoint(ctx context.Context, request interface{}) (interface{}, error) {
+	req := request.(*serviceTokenRequest)
+
+	client, _ := CtxAuthorizedAPIKeyOwner(ctx)
+	if !utils.StringSliceContains(e.targets[client], req.target) {
+		return nil, xerror.Wrap(xerror.New(ErrorTargetNotAllowed, req.target), ErrorForbidden)
+	}
+	authorizedScopes := ctxAuthorizedScopes(ctx)
+	for _, scope := range req.scopes {
+		if !utils.StringSliceContains(authorizedScopes, scope) {
+			return nil, xerror.Wrap(xerror.New(ErrorScopeNotAllowed, scope), ErrorForbidden)
+		}
+	}
+
+	token, err := e.tokenIssuer.IssueServiceToken(client, req.target, req.scopes...)
+	if err != nil {
+		return nil, err
+	}
+	return &utils.ServiceTokenResponse{AccessToken: token, TokenType: bearerTokenType}, nil
+}
+
+// Encoder implements the Route interface.
+func (e *ServiceTokenExchangeRoute) Encoder(_ context.Context, w http.ResponseWriter, resp interface{}) error {
+	w.Header().Add(contentTypeHeaderName, jsonContentTypeHeaderValue)
+	w.Header().Set(cacheControlHeaderName, noStoreHeaderValue)
+	return json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestServiceTokenExchangeRoute(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	targetTV, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience, utils.TokenVerifierServiceAudience("notifications", true))
+	assert.Nil(t, err)
+	store := utils.NewMemoryAPIKeyStore()
+	key, apiKey, err := utils.NewAPIKey("messages", "messages:read")
+	assert.Nil(t, err)
+	assert.Nil(t, store.StoreAPIKey(apiKey))
+
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil).SetAPIKeyStore(store)
+	router.MountRoute(NewServiceTokenExchangeRoute("/token", ti, map[string][]string{"messages": {"notifications"}}))
+	ts := httptest.NewServer(router.GetMux())
+	defer ts.Close()
+
+	source := utils.NewRemoteServiceTokenSource(ts.URL+"/v1/token", http.DefaultClient, key, "notifications", utils.DefaultServiceTokenRenewalMargin, "messages:read")
+	st, err := source.GetServiceToken()
+	assert.Nil(t, err)
+	claims, err := targetTV.VerifyTokenClaims(st)
+	assert.Nil(t, err)
+	assert.Equal(t, utils.TokenAccessSystemRole, claims.Role)
+	assert.Equal(t, "messages", claims.Client)
+	assert.Equal(t, []string{"notifications"}, claims.Audience)
+	assert.Equal(t, []string{"messages:read"}, claims.Scopes)
+
+	post := func(key, token string, form url.Values) int {
+		req, err := http.NewRequest("POST", ts.URL+"/v1/token", strings.NewReader(form.Encode()))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set(apiKeyHeader, key)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Nil(t, res.Body.Close())
+		return res.StatusCode
+	}
+	sat, err := ti.IssueAccessSystemToken()
+	assert.Nil(t, err)
+
+	assert.Equal(t, http.StatusOK, post(key, "", url.Values{"target": {"notifications"}}))
+	assert.Equal(t, http.StatusForbidden, post(key, "", url.Values{"target": {"billing"}}))
+	assert.Equal(t, http.StatusForbidden, post(key, "", url.Values{"target": {"notifications"}, "scope": {"messages:write"}}))
+	assert.Equal(t, http.StatusBadRequest, post(key, "", url.Values{}))
+	assert.Equal(t, http.StatusUnauthorized, post("bad", "", url.Values{"target": {"notifications"}}))
+	assert.Equal(t, http.StatusForbidden, post("", sat, url.Values{"target": {"notifications"}}))
+
+	_, err = utils.NewRemoteServiceTokenSource(ts.URL+"/v1/token", http.DefaultClient, "bad", "notifications", utils.DefaultServiceTokenRenewalMargin).GetServiceToken()
+	assert.Equal(t, utils.ErrorUnableToExchangeServiceToken, err.Error())
+}
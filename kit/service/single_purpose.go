@@ -0,0 +1,129 @@
+package service
+
+import (
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"net/http"
+	"strings"
+)
+
+const (
+	ctxLabelSinglePurposeTokenParam  = "singlePurposeTokenParam"
+	ctxLabelSinglePurposeTokenClaims = "singlePurposeTokenClaims"
+)
+
+// singlePurposeTokenClaims holds the verified claims of a single purpose token.
+type singlePurposeTokenClaims struct {
+	claims       *utils.TokenClaims
+	customClaims map[string]interface{}
+}
+
+func ctxWithSinglePurposeTokenParam(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, ctxLabelSinglePurposeTokenParam, token)
+}
+
+func ctxSinglePurposeTokenParam(ctx context.Context) string {
+	return EnsureString(ctx, ctxLabelSinglePurposeTokenParam)
+}
+
+func ctxWithSinglePurposeTokenClaims(ctx context.Context, claims *utils.TokenClaims, customClaims map[string]interface{}) context.Context {
+	return context.WithValue(ctx, ctxLabelSinglePurposeTokenClaims, &singlePurposeTokenClaims{claims: claims, customClaims: customClaims})
+}
+
+// CtxSinglePurposeTokenClaims extracts the verified claims of the single purpose token stored in the request context,
+// if the route is a SinglePurposeTokenRoute.
+func CtxSinglePurposeTokenClaims(ctx context.Context) (*utils.TokenClaims, map[string]interface{}, bool) {
+	if v, ok := ctx.Value(ctxLabelSinglePurposeTokenClaims).(*singlePurposeTokenClaims); ok && v != nil {
+		return v.claims, v.customClaims, true
+	}
+	return nil, nil, false
+}
+
+// NewSinglePurposeTokenExtractor initializes a go-kit before handler that extracts a single purpose token from the
+// given query parameter into the context.
+func NewSinglePurposeTokenExtractor(param string) kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if token := strings.TrimSpace(r.URL.Query().Get(param)); token != "" {
+			return ctxWithSinglePurposeTokenParam(ctx, token)
+		}
+		return ctx
+	}
+}
+
+// SinglePurposeTokenRoute describes a route authenticated by a single purpose token instead of an access token.
+type SinglePurposeTokenRoute interface {
+	GetSinglePurposeTokenDescriptor() utils.SinglePurposeTokenDescriptor
+	GetSinglePurposeTokenParam() string
+}
+
+// SinglePurposeTokenMixin is a mixin implementing the Authentication and SinglePurposeTokenRoute interfaces. It replaces
+// AuthenticationMixin in routes authenticated by a single purpose token.
+type SinglePurposeTokenMixin struct {
+	descriptor utils.SinglePurposeTokenDescriptor
+	param      string
+}
+
+// NewSinglePurposeTokenMixin initializes a new SinglePurposeTokenMixin. The token is read from the Authorization header,
+// or from the given query parameter if not empty, e.g. for links sent by email.
+func NewSinglePurposeTokenMixin(descriptor utils.SinglePurposeTokenDescriptor, param string) SinglePurposeTokenMixin {
+	return SinglePurposeTokenMixin{descriptor: descriptor, param: param}
+}
+
+// IsAuthenticated implements the Authentication interface.
+func (m *SinglePurposeTokenMixin) IsAuthenticated() bool {
+	return true
+}
+
+// GetSinglePurposeTokenDescriptor implements the SinglePurposeTokenRoute interface.
+func (m *SinglePurposeTokenMixin) GetSinglePurposeTokenDescriptor() utils.SinglePurposeTokenDescriptor {
+	return m.descriptor
+}
+
+// GetSinglePurposeTokenParam implements the SinglePurposeTokenRoute interface.
+func (m *SinglePurposeTokenMixin) GetSinglePurposeTokenParam() string {
+	return m.param
+}
+
+// NewSinglePurposeTokenMiddleware requires a valid single purpose token for the given descriptor, from the
+// Authorization header or the query parameter, and attaches its verified sub and claims to the context.
+func NewSinglePurposeTokenMiddleware(tokenVerifier utils.TokenVerifier, descriptor utils.SinglePurposeTokenDescriptor) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (resp interface{}, err error) {
+			if ctx, err = requireSinglePurposeToken(ctx, tokenVerifier, descriptor); err != nil {
+				return nil, auditAuthenticationFailure(ctx, err)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+func requireSinglePurposeToken(ctx context.Context, tokenVerifier utils.TokenVerifier, descriptor utils.SinglePurposeTokenDescriptor) (context.Context, error) {
+	token := getToken(ctx)
+	if token == "" {
+		token = ctxSinglePurposeTokenParam(ctx)
+	}
+	if token == "" {
+		return ctx, xerror.Wrap(xerror.New(ErrorMissingToken), ErrorUnauthorized, ctx)
+	}
+	v := verification{credential: token, verifier: tokenVerifier, descriptor: descriptor}
+	return authenticateOnce(ctx, v, func(ctx context.Context) (context.Context, error) {
+		return verifySinglePurposeToken(ctx, tokenVerifier, descriptor, token)
+	})
+}
+
+func verifySinglePurposeToken(ctx context.Context, tokenVerifier utils.TokenVerifier, descriptor utils.SinglePurposeTokenDescriptor, token string) (context.Context, error) {
+	claims, customClaims, err := tokenVerifier.VerifySinglePurposeTokenClaims(token, descriptor)
+	if err != nil {
+		return ctx, xerror.Wrap(err, ErrorUnauthorized)
+	}
+	ctx = ctxWithSinglePurposeTokenClaims(ctx, claims, customClaims)
+	return ctxWithAuthorizedRole(ctxWithAuthorizedSub(ctx, claims.Subject), claims.Role), nil
+}
+
+func getSinglePurposeTokenRoute(route Route) (SinglePurposeTokenRoute, bool) {
+	singlePurposeTokenRoute, ok := route.(SinglePurposeTokenRoute)
+	return singlePurposeTokenRoute, ok && singlePurposeTokenRoute.GetSinglePurposeTokenDescriptor() != nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+)
+
+type testSinglePurposeTokenRoute struct {
+	SinglePurposeTokenMixin
+	MethodAndPathMixin
+	JSONEncoderMixin
+	JSONErrorEncoderMixin
+	AdvancedRouteMixin
+}
+
+func (r *testSinglePurposeTokenRoute) Endpoint(ctx context.Context, request interface{}) (interface{}, error) {
+	claims, customClaims, ok := CtxSinglePurposeTokenClaims(ctx)
+	if !ok {
+		return nil, nil
+	}
+	return map[string]interface{}{"sub": claims.Subject, "email": customClaims["email"]}, nil
+}
+
+func (r *testSinglePurposeTokenRoute) Decoder(_ context.Context, _ *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func TestSinglePurposeTokenRoute(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	descriptor := utils.NewSinglePurposeTokenDescriptor("confirm-email", true, utils.DefaultSinglePurposeTokenLifetime, map[string]reflect.Type{"email": reflect.TypeOf("")})
+	otherDescriptor := utils.NewSinglePurposeTokenDescriptor("reset-password", true, utils.DefaultSinglePurposeTokenLifetime, map[string]reflect.Type{"email": reflect.TypeOf("")})
+
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil)
+	router.MountRoute(&testSinglePurposeTokenRoute{
+		SinglePurposeTokenMixin: NewSinglePurposeTokenMixin(descriptor, "token"),
+		MethodAndPathMixin:      NewMethodAndPathMixin("GET", "/confirm"),
+		AdvancedRouteMixin:      NewAdvancedRouteMixin(false, false),
+	})
+	ts := httptest.NewServer(router.GetMux())
+	defer ts.Close()
+
+	spt, err := ti.IssueSinglePurposeToken(descriptor, 1, map[string]interface{}{"email": "a@b.c"})
+	assert.Nil(t, err)
+	otherSPT, err := ti.IssueSinglePurposeToken(otherDescriptor, 1, map[string]interface{}{"email": "a@b.c"})
+	assert.Nil(t, err)
+	accessToken, err := ti.IssueAccessUserToken(1)
+	assert.Nil(t, err)
+
+	get := func(authorization, param string) (int, map[string]interface{}) {
+		req, err := http.NewRequest("GET", ts.URL+"/v1/confirm?"+url.Values{"token": {param}}.Encode(), nil)
+		assert.Nil(t, err)
+		if authorization != "" {
+			req.Header.Set("Authorization", "Bearer "+authorization)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		defer res.Body.Close()
+		body := map[string]interface{}{}
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&body))
+		return res.StatusCode, body
+	}
+
+	status, body := get(spt, "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{"sub": float64(1), "email": "a@b.c"}, body["data"])
+	status, body = get("", spt)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, map[string]interface{}{"sub": float64(1), "email": "a@b.c"}, body["data"])
+
+	status, body = get("", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, "unauthorized: missing token", body["error"])
+	status, _ = get(otherSPT, "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = get("", accessToken)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = get("", "bad")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestSinglePurposeTokenMiddleware(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	descriptor := utils.NewSinglePurposeTokenDescriptor("confirm-email", false, utils.DefaultSinglePurposeTokenLifetime, map[string]reflect.Type{})
+	spt, err := ti.IssueSinglePurposeToken(descriptor, 0, map[string]interface{}{})
+	assert.Nil(t, err)
+
+	f := NewSinglePurposeTokenMiddleware(tv, descriptor)(func(ctx context.Context, request interface{}) (interface{}, error) {
+		av := NewContextAuthVerifier(ctx)
+		assert.False(t, av.IsAnonymous())
+		assert.NotNil(t, av.AcceptAnyAccessUserToken().AcceptAccessSystemToken().Verify())
+		claims, _, ok := CtxSinglePurposeTokenClaims(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, "confirm-email", claims.Role)
+		return nil, nil
+	})
+	_, err = f(ctxWithSinglePurposeTokenParam(context.Background(), spt), nil)
+	assert.Nil(t, err)
+}
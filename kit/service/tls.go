@@ -0,0 +1,38 @@
+package service
+
+import (
+	"golang.org/x/net/context"
+	"net/http"
+)
+
+const (
+	ctxLabelClientIdentities = "clientIdentities"
+)
+
+// ClientCertificateExtractor is a go-kit before handler that puts the identities of a verified TLS client certificate
+// in the context: its subject common name, followed by its DNS subject alternative names. Unverified certificates are
+// ignored, so the server must be configured to verify them, e.g. with Router.Serve.
+func ClientCertificateExtractor(ctx context.Context, r *http.Request) context.Context {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ctx
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	identities := make([]string, 0, len(cert.DNSNames)+1)
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	return ctxWithClientIdentities(ctx, identities)
+}
+
+func ctxWithClientIdentities(ctx context.Context, identities []string) context.Context {
+	return context.WithValue(ctx, ctxLabelClientIdentities, identities)
+}
+
+// CtxClientIdentities extracts the identities of the verified TLS client certificate stored in the request context.
+func CtxClientIdentities(ctx context.Context) []string {
+	if v, ok := ctx.Value(ctxLabelClientIdentities).([]string); ok {
+		return v
+	}
+	return nil
+}
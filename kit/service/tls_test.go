@@ -0,0 +1,114 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/ConnectCorp/go-kit/kit/test"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestClientCertificateExtractor(t *testing.T) {
+	ca := test.MustNewCertificate("ca", nil, nil)
+	serverCert := test.MustNewCertificate("server", []string{"127.0.0.1", "localhost"}, ca)
+	clientCert := test.MustNewCertificate("billing", []string{"billing.internal"}, ca)
+	otherCert := test.MustNewCertificate("other", nil, test.MustNewCertificate("other-ca", nil, nil))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+
+	var identities []string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identities = CtxClientIdentities(ClientCertificateExtractor(context.Background(), r))
+	}))
+	serverPair, err := tls.X509KeyPair(serverCert.CertPEM, serverCert.KeyPEM)
+	assert.Nil(t, err)
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{serverPair}, ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(cert *test.Certificate) error {
+		tlsConfig := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+		if cert != nil {
+			pair, err := tls.X509KeyPair(cert.CertPEM, cert.KeyPEM)
+			assert.Nil(t, err)
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		identities = nil
+		res, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}).Get(ts.URL)
+		if err == nil {
+			assert.Nil(t, res.Body.Close())
+		}
+		return err
+	}
+
+	assert.Nil(t, get(clientCert))
+	assert.Equal(t, []string{"billing", "billing.internal"}, identities)
+	assert.Nil(t, get(nil))
+	assert.Nil(t, identities)
+	assert.Nil(t, get(otherCert))
+	assert.Nil(t, identities)
+	assert.Nil(t, CtxClientIdentities(ClientCertificateExtractor(context.Background(), test.MustNewRequest())))
+}
+
+func TestAuthVerifierWithClientIdentities(t *testing.T) {
+	systemTokenCtx := ctxWithAuthorizedSub(ctxWithAuthorizedRole(context.Background(), utils.TokenAccessSystemRole), 0)
+	clientCtx := ctxWithClientIdentities(systemTokenCtx, []string{"billing", "billing.internal"})
+
+	assert.Nil(t, NewContextAuthVerifier(systemTokenCtx).AcceptAccessSystemToken().Verify())
+	assert.NotNil(t, NewContextAuthVerifier(systemTokenCtx).AcceptAccessSystemToken().RequireClientIdentities("billing").Verify())
+	assert.Nil(t, NewContextAuthVerifier(clientCtx).AcceptAccessSystemToken().RequireClientIdentities("billing").Verify())
+	assert.Nil(t, NewContextAuthVerifier(clientCtx).AcceptAccessSystemToken().RequireClientIdentities("payments", "billing.internal").Verify())
+	assert.NotNil(t, NewContextAuthVerifier(clientCtx).AcceptAccessSystemToken().RequireClientIdentities("payments").Verify())
+	assert.NotNil(t, NewContextAuthVerifier(clientCtx).RequireClientIdentities("billing").Verify())
+}
+
+func TestRouterWithClientCertificate(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	token, err := ti.IssueAccessSystemToken()
+	assert.Nil(t, err)
+
+	ca := test.MustNewCertificate("ca", nil, nil)
+	serverCert := test.MustNewCertificate("server", []string{"localhost"}, ca)
+	clientCert := test.MustNewCertificate("billing", nil, ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil)
+	router.MountRoute(newTestPolicyRoute("/internal", NewAuthVerifierPolicy(func(_ context.Context, av AuthVerifier) AuthVerifier {
+		return av.AcceptAccessSystemToken().RequireClientIdentities("billing")
+	})))
+	ts := httptest.NewUnstartedServer(router.GetMux())
+	serverPair, err := tls.X509KeyPair(serverCert.CertPEM, serverCert.KeyPEM)
+	assert.Nil(t, err)
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{serverPair}, ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(withCert bool) int {
+		tlsConfig := &tls.Config{RootCAs: pool, ServerName: "localhost"}
+		if withCert {
+			pair, err := tls.X509KeyPair(clientCert.CertPEM, clientCert.KeyPEM)
+			assert.Nil(t, err)
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+		req, err := http.NewRequest("GET", ts.URL+"/v1/internal", nil)
+		assert.Nil(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}).Do(req)
+		assert.Nil(t, err)
+		assert.Nil(t, res.Body.Close())
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, get(true))
+	assert.Equal(t, http.StatusForbidden, get(false))
+}
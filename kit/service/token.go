@@ -3,9 +3,11 @@ package service
 import (
 	"github.com/ConnectCorp/go-kit/kit/utils"
 	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
 	"golang.org/x/net/context"
 	"gopkg.in/ibrt/go-xerror.v2/xerror"
 	"net/http"
+	"reflect"
 	"strings"
 )
 
@@ -17,10 +19,14 @@ const (
 )
 
 const (
-	authorizationHeader    = "Authorization"
-	ctxLabelToken          = "token"
-	ctxLabelAuthorizedSub  = "authorizedSub"
-	ctxLabelAuthorizedRole = "authorizedRole"
+	authorizationHeader      = "Authorization"
+	ctxLabelToken            = "token"
+	ctxLabelAuthorizedSub    = "authorizedSub"
+	ctxLabelAuthorizedRole   = "authorizedRole"
+	ctxLabelAuthorizedScopes = "authorizedScopes"
+	ctxLabelAuthorizedActor  = "authorizedActor"
+	ctxLabelAuthorizedClient = "authorizedClient"
+	ctxLabelAuthentication   = "authentication"
 )
 
 func ctxWithAuthorizedRole(ctx context.Context, authorizedRole string) context.Context {
@@ -49,6 +55,94 @@ func ctxAuthorizedSub(ctx context.Context) int64 {
 	return EnsureInt64(ctx, ctxLabelAuthorizedSub)
 }
 
+func ctxWithAuthorizedScopes(ctx context.Context, authorizedScopes []string) context.Context {
+	return context.WithValue(ctx, ctxLabelAuthorizedScopes, authorizedScopes)
+}
+
+// ctxAuthorizedScopes extracts the verified scopes stored in the request context.
+func ctxAuthorizedScopes(ctx context.Context) []string {
+	if v, ok := ctx.Value(ctxLabelAuthorizedScopes).([]string); ok {
+		return v
+	}
+	return nil
+}
+
+func ctxWithAuthorizedActor(ctx context.Context, actor *utils.TokenActor) context.Context {
+	return context.WithValue(ctx, ctxLabelAuthorizedActor, actor)
+}
+
+// CtxAuthorizedActor extracts the verified actor stored in the request context, if the token is delegated.
+func CtxAuthorizedActor(ctx context.Context) (int64, bool) {
+	if v, ok := ctx.Value(ctxLabelAuthorizedActor).(*utils.TokenActor); ok && v != nil {
+		return v.Subject, true
+	}
+	return 0, false
+}
+
+func ctxWithAuthorizedClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, ctxLabelAuthorizedClient, client)
+}
+
+// CtxAuthorizedClient extracts the verified calling service stored in the request context, if the token is a service token.
+func CtxAuthorizedClient(ctx context.Context) string {
+	return EnsureString(ctx, ctxLabelAuthorizedClient)
+}
+
+// authentication is the outcome of a verification of the request credentials, stored in the context so that later
+// identical verifications reuse it, e.g. the authentication middleware after the Router before handler.
+type authentication struct {
+	verification verification
+	err          error
+}
+
+// verification describes what was verified: the credential, and the verifier and descriptor it was verified with.
+type verification struct {
+	credential string
+	verifier   interface{}
+	descriptor utils.SinglePurposeTokenDescriptor
+}
+
+func (v verification) matches(other verification) bool {
+	return v.credential == other.credential && isSameValue(v.verifier, other.verifier) && isSameValue(v.descriptor, other.descriptor)
+}
+
+// isSameValue compares two values without panicking on uncomparable types, which are never the same.
+func isSameValue(a, b interface{}) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && (t == nil || t.Comparable()) && a == b
+}
+
+func ctxWithAuthentication(ctx context.Context, verification verification, err error) context.Context {
+	return context.WithValue(ctx, ctxLabelAuthentication, &authentication{verification: verification, err: err})
+}
+
+func ctxAuthentication(ctx context.Context) (*authentication, bool) {
+	v, ok := ctx.Value(ctxLabelAuthentication).(*authentication)
+	return v, ok
+}
+
+// authenticateFunc verifies the credentials of the request and attaches the verified role, sub and claims to the context.
+type authenticateFunc func(ctx context.Context) (context.Context, error)
+
+// newAuthenticator initializes a go-kit before handler that verifies the credentials of the request, so that verified
+// claims are visible to all the middlewares, e.g. logging. The authentication middlewares report the error, if any.
+func newAuthenticator(authenticate authenticateFunc) kithttp.RequestFunc {
+	return func(ctx context.Context, _ *http.Request) context.Context {
+		ctx, _ = authenticate(ctx)
+		return ctx
+	}
+}
+
+// authenticateOnce verifies the credentials of the request and stores the outcome in the context, unless the same
+// verification has already been done, e.g. by a before handler made by newAuthenticator.
+func authenticateOnce(ctx context.Context, verification verification, authenticate authenticateFunc) (context.Context, error) {
+	if authentication, ok := ctxAuthentication(ctx); ok && authentication.verification.matches(verification) {
+		return ctx, authentication.err
+	}
+	ctx, err := authenticate(ctx)
+	return ctxWithAuthentication(ctx, verification, err), err
+}
+
 // TokenExtractor is a go-kit before handler that extracts a token from the Authorization header into the context.
 func TokenExtractor(ctx context.Context, r *http.Request) context.Context {
 	token := r.Header.Get(authorizationHeader)
@@ -61,8 +155,15 @@ func TokenExtractor(ctx context.Context, r *http.Request) context.Context {
 // AuthVerifier describes a system for ACL.
 type AuthVerifier interface {
 	AcceptAccessSystemToken() AuthVerifier
+	AcceptServiceTokenFromClients(clients ...string) AuthVerifier
 	AcceptAnyAccessUserToken() AuthVerifier
 	AcceptAccessUserTokenForSubs(subs ...int64) AuthVerifier
+	AcceptAnyAPIKey() AuthVerifier
+	AcceptAPIKeyForOwners(owners ...string) AuthVerifier
+	RequireScopes(scopes ...string) AuthVerifier
+	RequireClientIdentities(identities ...string) AuthVerifier
+	AcceptDelegatedTokens() AuthVerifier
+	IsAnonymous() bool
 	Verify() error
 	VerifyAndGet() (string, int64, error)
 }
@@ -70,17 +171,28 @@ type AuthVerifier interface {
 type contextAuthVerifier struct {
 	ctx                          context.Context
 	acceptAccessSystemToken      bool
+	acceptServiceTokenClients    []string
 	acceptAnyAccessUserToken     bool
 	acceptAccessUserTokenForSubs []int64
+	acceptAnyAPIKey              bool
+	acceptAPIKeyForOwners        []string
+	requiredScopes               []string
+	requiredClientIdentities     []string
+	acceptDelegatedTokens        bool
 }
 
 // NewContextAuthVerifier creates an AuthVerifier that uses the go-kit context for sourcing authorization data.
 func NewContextAuthVerifier(ctx context.Context) AuthVerifier {
 	return &contextAuthVerifier{
-		ctx: ctx,
+		ctx:                          ctx,
 		acceptAccessSystemToken:      false,
+		acceptServiceTokenClients:    make([]string, 0),
 		acceptAnyAccessUserToken:     false,
 		acceptAccessUserTokenForSubs: make([]int64, 0),
+		acceptAnyAPIKey:              false,
+		acceptAPIKeyForOwners:        make([]string, 0),
+		requiredScopes:               make([]string, 0),
+		requiredClientIdentities:     make([]string, 0),
 	}
 }
 
@@ -90,6 +202,13 @@ func (av *contextAuthVerifier) AcceptAccessSystemToken() AuthVerifier {
 	return av
 }
 
+// AcceptServiceTokenFromClients implements the AuthVerifier interface. Service tokens are system tokens, so they are also
+// accepted by AcceptAccessSystemToken.
+func (av *contextAuthVerifier) AcceptServiceTokenFromClients(clients ...string) AuthVerifier {
+	av.acceptServiceTokenClients = append(av.acceptServiceTokenClients, clients...)
+	return av
+}
+
 // AcceptAnyUserToken implements the AuthVerifier interface.
 func (av *contextAuthVerifier) AcceptAnyAccessUserToken() AuthVerifier {
 	av.acceptAnyAccessUserToken = true
@@ -102,27 +221,140 @@ func (av *contextAuthVerifier) AcceptAccessUserTokenForSubs(subs ...int64) AuthV
 	return av
 }
 
-// VerifyAndGet implements the AuthVerifier interface.
+// AcceptAnyAPIKey implements the AuthVerifier interface.
+func (av *contextAuthVerifier) AcceptAnyAPIKey() AuthVerifier {
+	av.acceptAnyAPIKey = true
+	return av
+}
+
+// AcceptAPIKeyForOwners implements the AuthVerifier interface.
+func (av *contextAuthVerifier) AcceptAPIKeyForOwners(owners ...string) AuthVerifier {
+	av.acceptAPIKeyForOwners = append(av.acceptAPIKeyForOwners, owners...)
+	return av
+}
+
+// RequireScopes implements the AuthVerifier interface. The token or API key must carry all the given scopes. Verifiers
+// that do not require any scope accept scoped credentials too, so routes restricted to scoped tokens must call it.
+func (av *contextAuthVerifier) RequireScopes(scopes ...string) AuthVerifier {
+	av.requiredScopes = append(av.requiredScopes, scopes...)
+	return av
+}
+
+// RequireClientIdentities implements the AuthVerifier interface. The request must also present a verified TLS client
+// certificate for one of the given identities, in addition to satisfying the other requirements.
+func (av *contextAuthVerifier) RequireClientIdentities(identities ...string) AuthVerifier {
+	av.requiredClientIdentities = append(av.requiredClientIdentities, identities...)
+	return av
+}
+
+// AcceptDelegatedTokens implements the AuthVerifier interface. Delegated tokens, used by an actor on behalf of their
+// subject, are rejected unless the verifier accepts them explicitly.
+func (av *contextAuthVerifier) AcceptDelegatedTokens() AuthVerifier {
+	av.acceptDelegatedTokens = true
+	return av
+}
+
+// IsAnonymous implements the AuthVerifier interface. It returns true if the request was not authenticated, e.g. on
+// routes with optional authentication.
+func (av *contextAuthVerifier) IsAnonymous() bool {
+	return ctxAuthorizedRole(av.ctx) == ""
+}
+
+// VerifyAndGet implements the AuthVerifier interface. The decision is recorded by the AuditSink of the Router, if any.
 func (av *contextAuthVerifier) VerifyAndGet() (string, int64, error) {
+	authorizedRole, authorizedSub, reason := av.verify()
+	if reason != "" {
+		auditDecision(av.ctx, AuditDecisionDeny, reason)
+		return "", 0, xerror.New(ErrorForbidden, av)
+	}
+	auditDecision(av.ctx, AuditDecisionAllow, "")
+	return authorizedRole, authorizedSub, nil
+}
+
+// verify returns the authorized role and sub, or the reason why the request is forbidden.
+func (av *contextAuthVerifier) verify() (string, int64, string) {
 	authorizedRole := ctxAuthorizedRole(av.ctx)
 	authorizedSub := ctxAuthorizedSub(av.ctx)
 
-	if authorizedRole == utils.TokenAccessSystemRole && av.acceptAccessSystemToken {
-		return authorizedRole, authorizedSub, nil
+	if !av.hasRequiredScopes() {
+		return "", 0, auditReasonMissingScopes
+	}
+
+	if !av.hasRequiredClientIdentity() {
+		return "", 0, auditReasonMissingClientIdentity
+	}
+
+	if _, isDelegated := CtxAuthorizedActor(av.ctx); isDelegated && !av.acceptDelegatedTokens {
+		return "", 0, auditReasonDelegatedToken
+	}
+
+	if authorizedRole == utils.TokenAccessSystemRole {
+		if av.acceptAccessSystemToken {
+			return authorizedRole, authorizedSub, ""
+		}
+		authorizedClient := CtxAuthorizedClient(av.ctx)
+		for _, client := range av.acceptServiceTokenClients {
+			if authorizedClient != "" && client == authorizedClient {
+				return authorizedRole, authorizedSub, ""
+			}
+		}
+	}
+
+	if authorizedRole == APIKeyRole {
+		authorizedOwner, _ := CtxAuthorizedAPIKeyOwner(av.ctx)
+		if av.acceptAnyAPIKey {
+			return authorizedRole, authorizedSub, ""
+		}
+		for _, owner := range av.acceptAPIKeyForOwners {
+			if owner == authorizedOwner {
+				return authorizedRole, authorizedSub, ""
+			}
+		}
 	}
 
 	if authorizedRole == utils.TokenAccessUserRole {
 		if av.acceptAnyAccessUserToken {
-			return authorizedRole, authorizedSub, nil
+			return authorizedRole, authorizedSub, ""
 		}
 		for _, sub := range av.acceptAccessUserTokenForSubs {
 			if sub == authorizedSub {
-				return authorizedRole, authorizedSub, nil
+				return authorizedRole, authorizedSub, ""
 			}
 		}
 	}
 
-	return "", 0, xerror.New(ErrorForbidden, av)
+	return "", 0, auditReasonRoleNotAccepted
+}
+
+func (av *contextAuthVerifier) hasRequiredScopes() bool {
+	authorizedScopes := ctxAuthorizedScopes(av.ctx)
+	for _, requiredScope := range av.requiredScopes {
+		found := false
+		for _, authorizedScope := range authorizedScopes {
+			if authorizedScope == requiredScope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (av *contextAuthVerifier) hasRequiredClientIdentity() bool {
+	if len(av.requiredClientIdentities) == 0 {
+		return true
+	}
+	for _, clientIdentity := range CtxClientIdentities(av.ctx) {
+		for _, requiredClientIdentity := range av.requiredClientIdentities {
+			if clientIdentity == requiredClientIdentity {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // Verify implements the AuthVerifier interface.
@@ -131,12 +363,25 @@ func (av *contextAuthVerifier) Verify() error {
 	return err
 }
 
-// NewTokenMiddleware requires a valid token for the request, attaches verified role and sub in the context.
+// NewTokenMiddleware requires a valid token for the request, attaches verified role, sub, scopes, and actor in the context.
 func NewTokenMiddleware(tokenVerifier utils.TokenVerifier) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (resp interface{}, err error) {
 			if ctx, err = requireToken(ctx, tokenVerifier); err != nil {
-				return nil, err
+				return nil, auditAuthenticationFailure(ctx, err)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// NewOptionalTokenMiddleware verifies the token if one is attached to the request, as NewTokenMiddleware, and otherwise
+// lets the request through anonymously.
+func NewOptionalTokenMiddleware(tokenVerifier utils.TokenVerifier) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (resp interface{}, err error) {
+			if ctx, err = checkToken(ctx, tokenVerifier); err != nil {
+				return nil, auditAuthenticationFailure(ctx, err)
 			}
 			return next(ctx, request)
 		}
@@ -148,7 +393,7 @@ func NewNoTokenMiddleware() endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (resp interface{}, err error) {
 			if ctx, err = forbidToken(ctx); err != nil {
-				return nil, err
+				return nil, auditAuthenticationFailure(ctx, err)
 			}
 			return next(ctx, request)
 		}
@@ -168,16 +413,24 @@ func checkToken(ctx context.Context, tokenVerifier utils.TokenVerifier) (context
 	if token == "" {
 		return ctxWithAuthorizedSub(ctx, 0), nil
 	}
-	userID, role, err := tokenVerifier.VerifyToken(token)
+	return authenticateOnce(ctx, verification{credential: token, verifier: tokenVerifier}, func(ctx context.Context) (context.Context, error) {
+		return verifyToken(ctx, tokenVerifier, token)
+	})
+}
+
+func verifyToken(ctx context.Context, tokenVerifier utils.TokenVerifier, token string) (context.Context, error) {
+	claims, err := tokenVerifier.VerifyTokenClaims(token)
 	if err != nil {
 		return ctx, xerror.Wrap(err, ErrorUnauthorized)
 	}
-	return ctxWithAuthorizedRole(ctxWithAuthorizedSub(ctx, userID), role), nil
+	ctx = ctxWithAuthorizedScopes(ctxWithAuthorizedActor(ctx, claims.Actor), claims.Scopes)
+	ctx = ctxWithAuthorizedClient(ctx, claims.Client)
+	return ctxWithAuthorizedRole(ctxWithAuthorizedSub(ctx, claims.Subject), claims.Role), nil
 }
 
 func forbidToken(ctx context.Context) (context.Context, error) {
 	token := getToken(ctx)
-	if token != "" {
+	if token != "" || ctxAPIKey(ctx) != "" {
 		return ctx, xerror.Wrap(xerror.New(ErrorMustNotAuthenticate), ErrorBadRequest, ctx)
 	}
 	return ctxWithAuthorizedSub(ctx, 0), nil
@@ -79,6 +79,35 @@ func TestToken(t *testing.T) {
 	_, err = tokenFunc(context.Background(), req)
 	assert.Equal(t, "unauthorized: missing token", err.Error())
 
+	scopedToken, err := ti.IssueScopedAccessUserToken(1, "messages:read", "admin:users")
+	assert.Nil(t, err)
+	scopedFunc := tokenMiddleware(func(ctx context.Context, request interface{}) (interface{}, error) {
+		assert.Equal(t, []string{"messages:read", "admin:users"}, ctxAuthorizedScopes(ctx))
+		return nil, NewContextAuthVerifier(ctx).AcceptAnyAccessUserToken().RequireScopes("admin:users").Verify()
+	})
+	_, err = scopedFunc(ctxWithToken(context.Background(), "Bearer "+scopedToken), req)
+	assert.Nil(t, err)
+
+	delegatedToken, err := ti.IssueDelegatedAccessUserToken(1, 2)
+	assert.Nil(t, err)
+	delegatedFunc := tokenMiddleware(func(ctx context.Context, request interface{}) (interface{}, error) {
+		actorSub, isDelegated := CtxAuthorizedActor(ctx)
+		assert.True(t, isDelegated)
+		assert.EqualValues(t, 2, actorSub)
+		assert.EqualValues(t, 1, ctxAuthorizedSub(ctx))
+		assert.NotNil(t, NewContextAuthVerifier(ctx).AcceptAnyAccessUserToken().Verify())
+		return nil, NewContextAuthVerifier(ctx).AcceptAnyAccessUserToken().AcceptDelegatedTokens().Verify()
+	})
+	_, err = delegatedFunc(ctxWithToken(context.Background(), "Bearer "+delegatedToken), req)
+	assert.Nil(t, err)
+	notDelegatedFunc := tokenMiddleware(func(ctx context.Context, request interface{}) (interface{}, error) {
+		_, isDelegated := CtxAuthorizedActor(ctx)
+		assert.False(t, isDelegated)
+		return nil, NewContextAuthVerifier(ctx).AcceptAnyAccessUserToken().AcceptDelegatedTokens().Verify()
+	})
+	_, err = notDelegatedFunc(ctx, req)
+	assert.Nil(t, err)
+
 	noTokenMiddleware := NewNoTokenMiddleware()
 	noTokenFunc := noTokenMiddleware(test.TerminationMiddleware)
 	_, err = noTokenFunc(ctx, req)
@@ -86,6 +115,52 @@ func TestToken(t *testing.T) {
 	_, err = noTokenFunc(context.Background(), req)
 	assert.Equal(t, "terminated", err.Error())
 
+	optionalTokenMiddleware := NewOptionalTokenMiddleware(tv)
+	optionalTokenFunc := optionalTokenMiddleware(func(ctx context.Context, request interface{}) (interface{}, error) {
+		return NewContextAuthVerifier(ctx).IsAnonymous(), nil
+	})
+	isAnonymous, err := optionalTokenFunc(ctx, req)
+	assert.Nil(t, err)
+	assert.Equal(t, false, isAnonymous)
+	isAnonymous, err = optionalTokenFunc(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, true, isAnonymous)
+	_, err = optionalTokenFunc(ctxWithToken(context.Background(), "bad"), req)
+	assert.Equal(t, "unauthorized: invalid token: token contains an invalid number of segments", err.Error())
+
+	// Middlewares reuse the outcome stored by the authenticator for the same verification, and verify again otherwise.
+	countingTV := &testCountingTokenVerifier{TokenVerifier: tv}
+	authenticator := newAuthenticator(func(ctx context.Context) (context.Context, error) {
+		return requireToken(ctx, countingTV)
+	})
+	authenticatedFunc := NewTokenMiddleware(countingTV)(func(ctx context.Context, request interface{}) (interface{}, error) {
+		return ctxAuthorizedSub(ctx), nil
+	})
+	sub, err := authenticatedFunc(authenticator(ctx, nil), req)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, sub)
+	assert.Equal(t, 1, countingTV.calls)
+	_, err = authenticatedFunc(authenticator(ctxWithToken(context.Background(), "bad"), nil), req)
+	assert.Equal(t, "unauthorized: invalid token: token contains an invalid number of segments", err.Error())
+	assert.Equal(t, 2, countingTV.calls)
+
+	otherTV, err := utils.NewTokenVerifier(keyID, publicKey, issuer, "connect-other")
+	assert.Nil(t, err)
+	_, err = NewTokenMiddleware(otherTV)(test.TerminationMiddleware)(authenticator(ctx, nil), req)
+	assert.Equal(t, "unauthorized: invalid token", err.Error())
+	descriptor := utils.NewSinglePurposeTokenDescriptor("confirm-email", true, utils.DefaultSinglePurposeTokenLifetime, nil)
+	_, err = NewSinglePurposeTokenMiddleware(countingTV, descriptor)(test.TerminationMiddleware)(authenticator(ctx, nil), req)
+	assert.Equal(t, "unauthorized: invalid token", err.Error())
+}
+
+type testCountingTokenVerifier struct {
+	utils.TokenVerifier
+	calls int
+}
+
+func (tv *testCountingTokenVerifier) VerifyTokenClaims(t string) (*utils.TokenClaims, error) {
+	tv.calls++
+	return tv.TokenVerifier.VerifyTokenClaims(t)
 }
 
 func TestAuthVerifier(t *testing.T) {
@@ -97,6 +172,12 @@ func TestAuthVerifier(t *testing.T) {
 	assert.NotNil(t, NewContextAuthVerifier(systemTokenCtx).Verify())
 	assert.NotNil(t, NewContextAuthVerifier(userTokenCtx1).Verify())
 
+	assert.True(t, NewContextAuthVerifier(context.Background()).IsAnonymous())
+	assert.True(t, NewContextAuthVerifier(ctxWithAuthorizedSub(context.Background(), 0)).IsAnonymous())
+	assert.NotNil(t, NewContextAuthVerifier(context.Background()).AcceptAnyAccessUserToken().AcceptAccessSystemToken().Verify())
+	assert.False(t, NewContextAuthVerifier(systemTokenCtx).IsAnonymous())
+	assert.False(t, NewContextAuthVerifier(userTokenCtx1).IsAnonymous())
+
 	role, sub, err := NewContextAuthVerifier(systemTokenCtx).AcceptAccessSystemToken().VerifyAndGet()
 	assert.Equal(t, utils.TokenAccessSystemRole, role)
 	assert.EqualValues(t, 0, sub)
@@ -121,4 +202,23 @@ func TestAuthVerifier(t *testing.T) {
 	assert.Nil(t, NewContextAuthVerifier(userTokenCtx1).AcceptAccessUserTokenForSubs(1, 2).Verify())
 	assert.Nil(t, NewContextAuthVerifier(userTokenCtx2).AcceptAccessUserTokenForSubs(1, 2).Verify())
 	assert.NotNil(t, NewContextAuthVerifier(userTokenCtx3).AcceptAccessUserTokenForSubs(1, 2).Verify())
+
+	serviceTokenCtx := ctxWithAuthorizedClient(systemTokenCtx, "messages")
+	assert.Nil(t, NewContextAuthVerifier(serviceTokenCtx).AcceptServiceTokenFromClients("messages").Verify())
+	assert.Nil(t, NewContextAuthVerifier(serviceTokenCtx).AcceptAccessSystemToken().Verify())
+	assert.NotNil(t, NewContextAuthVerifier(serviceTokenCtx).AcceptServiceTokenFromClients("notifications").Verify())
+	assert.NotNil(t, NewContextAuthVerifier(systemTokenCtx).AcceptServiceTokenFromClients("messages").Verify())
+	assert.NotNil(t, NewContextAuthVerifier(userTokenCtx1).AcceptServiceTokenFromClients("messages").Verify())
+
+	scopedUserTokenCtx1 := ctxWithAuthorizedScopes(userTokenCtx1, []string{"messages:read", "admin:users"})
+	scopedSystemTokenCtx := ctxWithAuthorizedScopes(systemTokenCtx, []string{"messages:read"})
+
+	assert.NotNil(t, NewContextAuthVerifier(userTokenCtx1).AcceptAnyAccessUserToken().RequireScopes("messages:read").Verify())
+	assert.Nil(t, NewContextAuthVerifier(scopedUserTokenCtx1).AcceptAnyAccessUserToken().RequireScopes("messages:read").Verify())
+	assert.Nil(t, NewContextAuthVerifier(scopedUserTokenCtx1).AcceptAnyAccessUserToken().RequireScopes("messages:read", "admin:users").Verify())
+	assert.NotNil(t, NewContextAuthVerifier(scopedUserTokenCtx1).AcceptAnyAccessUserToken().RequireScopes("messages:read", "messages:write").Verify())
+	assert.NotNil(t, NewContextAuthVerifier(scopedUserTokenCtx1).AcceptAccessSystemToken().RequireScopes("messages:read").Verify())
+	assert.Nil(t, NewContextAuthVerifier(scopedSystemTokenCtx).AcceptAccessSystemToken().RequireScopes("messages:read").Verify())
+	assert.NotNil(t, NewContextAuthVerifier(scopedSystemTokenCtx).AcceptAccessSystemToken().RequireScopes("admin:users").Verify())
+	assert.Nil(t, NewContextAuthVerifier(scopedSystemTokenCtx).AcceptAccessSystemToken().Verify())
 }
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
 	"github.com/ConnectCorp/go-kit/kit/utils"
 	"github.com/go-kit/kit/endpoint"
 	kitlog "github.com/go-kit/kit/log"
@@ -13,6 +15,8 @@ import (
 	"github.com/tylerb/graceful"
 	"golang.org/x/net/context"
 	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"log"
+	"net"
 	"net/http"
 	"reflect"
 	"time"
@@ -34,8 +38,11 @@ const (
 const (
 	contentTypeHeaderName          = "Content-Type"
 	jsonContentTypeHeaderValue     = "application/json"
+	cacheControlHeaderName         = "Cache-Control"
 	defaultShutdownLameDuckTimeout = 30 * time.Second
 	healthRoutePath                = "/health"
+	jwksRoutePath                  = "/.well-known/jwks.json"
+	jwksMaxAge                     = time.Hour
 )
 
 var (
@@ -46,6 +53,7 @@ var (
 		"Origin",
 		"Content-Type",
 		"Authorization",
+		"X-Connect-API-Key",
 	})
 	corsAllowedMethods = handlers.AllowedMethods([]string{"POST", "GET", "HEAD", "PUT", "DELETE"})
 	corsAllowedOrigins = handlers.AllowedOrigins([]string{"*"})
@@ -56,9 +64,13 @@ type Response struct {
 	Data interface{} `json:"data,omitempty"`
 }
 
-// ErrorResponse is the standard API error response for Go microservices.
+// ErrorResponse is the standard API error response for Go microservices. Code is a stable machine-readable code, Details
+// lists the invalid fields of the request, if any, and TraceID can be quoted to support.
 type ErrorResponse struct {
-	Error string `json:"error,omitempty"`
+	Error   string              `json:"error,omitempty"`
+	Code    string              `json:"code,omitempty"`
+	Details []*utils.FieldError `json:"details,omitempty"`
+	TraceID string              `json:"traceId,omitempty"`
 }
 
 // ErrorToStatusCode converts an error to the corresponding HTTP status code.
@@ -85,6 +97,11 @@ type Router struct {
 	//metricsReporter MetricsReporter
 	transportLogger kitlog.Logger
 	tokenVerifier   utils.TokenVerifier
+	apiKeyStore     utils.APIKeyStore
+	auditSink       AuditSink
+	middlewares     []*pipelineEntry
+	extractors      []kithttp.RequestFunc
+	finalizers      []kithttp.ResponseFunc
 	mux             *mux.Router
 	prefixMux       *mux.Router
 	newrelicApp     newrelic.Application
@@ -112,7 +129,7 @@ func NewRouter(
 		w.Write([]byte("ok"))
 	})
 
-	return &Router{
+	r := &Router{
 		rootCtx: context.Background(),
 		//metricsReporter: NewMetricsReporter(commonMetricsNamespace, svcName, dogstatsdEmitter),
 		transportLogger: NewTransportLogger(rootLogger, "REST"),
@@ -121,20 +138,52 @@ func NewRouter(
 		prefixMux:       mux.PathPrefix(prefix).Subrouter(),
 		newrelicApp:     newrelicApp,
 	}
+	r.middlewares = r.makeBuiltInMiddlewares()
+	return r
+}
+
+// SetAPIKeyStore makes authenticated routes mounted afterwards accept API keys as well as tokens. Their RoutePolicy
+// decides which API keys are authorized, e.g. NewAPIKeyPolicy.
+func (r *Router) SetAPIKeyStore(apiKeyStore utils.APIKeyStore) *Router {
+	r.apiKeyStore = apiKeyStore
+	return r
+}
+
+// SetAuditSink makes routes mounted afterwards record their authorization decisions in the given AuditSink: every
+// AuthVerifier decision, and every authentication failure.
+func (r *Router) SetAuditSink(auditSink AuditSink) *Router {
+	r.auditSink = auditSink
+	return r
 }
 
-// MountRoute mounts a Route on the Router.
+// MountRoute mounts a Route on the Router, using the current Router pipeline. It panics if the route is authenticated
+// but does not declare a RoutePolicy.
 func (r *Router) MountRoute(route Route) *Router {
 	var handler http.Handler
 
+	befores := []kithttp.RequestFunc{
+		WireExtractor, TokenExtractor, APIKeyExtractor, ClientCertificateExtractor,
+		RequestPathExtractor, RequestVarsExtractor, TraceIDExtractor,
+	}
+	if singlePurposeTokenRoute, ok := getSinglePurposeTokenRoute(route); ok && singlePurposeTokenRoute.GetSinglePurposeTokenParam() != "" {
+		befores = append(befores, NewSinglePurposeTokenExtractor(singlePurposeTokenRoute.GetSinglePurposeTokenParam()))
+	}
+	befores = append(befores, r.extractors...)
+	afters := append([]kithttp.ResponseFunc{TraceIDSetter}, r.finalizers...)
+	if pipelineRoute, ok := route.(PipelineRoute); ok {
+		befores = append(befores, pipelineRoute.GetRequestExtractors()...)
+		afters = append(afters, pipelineRoute.GetResponseFinalizers()...)
+	}
+	befores = append(befores, r.makePipelineExtractors(route)...)
+
 	handler = kithttp.NewServer(
 		r.rootCtx,
 		r.getEndpointWithMiddlewares(route),
 		route.Decoder,
 		route.Encoder,
-		kithttp.ServerBefore(WireExtractor, TokenExtractor, RequestPathExtractor, TraceIDExtractor),
+		kithttp.ServerBefore(befores...),
 		kithttp.ServerErrorEncoder(route.ErrorEncoder),
-		kithttp.ServerAfter(TraceIDSetter))
+		kithttp.ServerAfter(afters...))
 
 	//Optionally report performance metrics to newrelic
 	if r.newrelicApp != nil {
@@ -152,28 +201,53 @@ func (r *Router) MountRoute(route Route) *Router {
 }
 
 func (r *Router) getEndpointWithMiddlewares(route Route) endpoint.Endpoint {
-	middlewares := make([]endpoint.Middleware, 0, 10)
+	endpoint := route.Endpoint
 
-	if route.IsAuthenticated() {
-		middlewares = append(middlewares, NewTokenMiddleware(r.tokenVerifier))
-	} else {
-		middlewares = append(middlewares, NewNoTokenMiddleware())
+	if pipelineRoute, ok := route.(PipelineRoute); ok {
+		middlewares := pipelineRoute.GetMiddlewares()
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			endpoint = middlewares[i](endpoint)
+		}
 	}
 
-	if advancedRoute, ok := route.(AdvancedRoute); !ok || (ok && advancedRoute.EnableWireMiddleware()) {
-		middlewares = append(middlewares, NewWireMiddleware())
+	applied := make(map[string]bool, len(r.middlewares))
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		if middleware := r.middlewares[i].factory(route); middleware != nil {
+			endpoint = middleware(endpoint)
+			applied[r.middlewares[i].name] = true
+		}
 	}
-
-	// middlewares = append(middlewares, NewMetricsMiddleware(r.metricsReporter)
-	middlewares = append(middlewares, NewLoggingMiddleware(r.transportLogger))
-
-	endpoint := route.Endpoint
-	for _, middleware := range middlewares {
-		endpoint = middleware(endpoint)
+	for _, name := range getRequiredMiddlewareNames(route) {
+		if !applied[name] {
+			panic(xerror.New(ErrorMissingRequiredMiddleware, name, route.GetMethod(), route.GetPath()))
+		}
 	}
 	return endpoint
 }
 
+// MustMountJWKSRoute publishes the given public keys at the standard JWKS path, or panics if a key cannot be converted.
+// Keys are omitted from the set once they are retired.
+func (r *Router) MustMountJWKSRoute(keys []*utils.TokenVerificationKey) *Router {
+	if _, err := utils.NewJSONWebKeySet(keys, time.Time{}); err != nil {
+		panic(err)
+	}
+
+	r.mux.Methods("GET").Path(jwksRoutePath).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		keySet, err := utils.NewJSONWebKeySet(keys, time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set(contentTypeHeaderName, jsonContentTypeHeaderValue)
+		w.Header().Set(cacheControlHeaderName, fmt.Sprintf("public, max-age=%d", int64(jwksMaxAge.Seconds())))
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(keySet) // Ignores an encoding error.
+	})
+
+	return r
+}
+
 // GetMux returns the underlying Gorilla *mux.Router, useful for testing or custom configuration.
 func (r *Router) GetMux() *mux.Router {
 	return r.mux
@@ -198,8 +272,35 @@ func corsMiddleware(handler http.Handler) http.Handler {
 
 // Run exposes the Router on the given address spec. Blocks forever, or until a fatal error occurs.
 func (r *Router) Run(addr string) {
-	r.newrelicApp.StartTransaction("startup", nil, nil).End()
-	graceful.Run(addr, defaultShutdownLameDuckTimeout, r.mux)
+	if err := r.Serve(addr, nil); err != nil {
+		log.Fatalf("exit: %v\n", err)
+	}
+}
+
+// Serve exposes the Router on the given address spec, using TLS if a config is given. Blocks until the server is shut
+// down, or returns the fatal error that occurred. The TLS config must contain the server certificate. If it also
+// requests and verifies client certificates, their identities are available through CtxClientIdentities and
+// AuthVerifier.RequireClientIdentities.
+func (r *Router) Serve(addr string, tlsConfig *tls.Config) error {
+	if r.newrelicApp != nil {
+		r.newrelicApp.StartTransaction("startup", nil, nil).End()
+	}
+	srv := &graceful.Server{
+		Timeout: defaultShutdownLameDuckTimeout,
+		Server:  &http.Server{Addr: addr, Handler: r.mux, TLSConfig: tlsConfig},
+	}
+
+	var err error
+	if tlsConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	// The listener is closed on shutdown, which is not an error.
+	if opErr, ok := err.(*net.OpError); ok && opErr.Op == "accept" {
+		return nil
+	}
+	return err
 }
 
 // Route describes a route to an endpoint in a Router.
@@ -240,9 +341,15 @@ func (m *MethodAndPathMixin) GetPath() string {
 	return m.path
 }
 
-// AuthenticationMixin is a mixin implementing the Authentication interface.
+// OptionalAuthentication describes whether an endpoint accepts both authenticated and anonymous requests.
+type OptionalAuthentication interface {
+	IsAuthenticationOptional() bool
+}
+
+// AuthenticationMixin is a mixin implementing the Authentication and OptionalAuthentication interfaces.
 type AuthenticationMixin struct {
 	authenticated bool
+	optional      bool
 }
 
 // NewRequireAuthenticationMixin initializes a new AuthenticationMixin that requires authentication.
@@ -255,11 +362,23 @@ func NewRejectAuthenticationMixin() AuthenticationMixin {
 	return AuthenticationMixin{authenticated: false}
 }
 
+// NewOptionalAuthenticationMixin initializes a new AuthenticationMixin that verifies a token if present, or an API key if
+// present and the Router has an APIKeyStore, and otherwise lets the request through anonymously. Endpoints can use
+// AuthVerifier.IsAnonymous to tell anonymous requests apart.
+func NewOptionalAuthenticationMixin() AuthenticationMixin {
+	return AuthenticationMixin{authenticated: false, optional: true}
+}
+
 // IsAuthenticated implements the Authenticated interface.
 func (a *AuthenticationMixin) IsAuthenticated() bool {
 	return a.authenticated
 }
 
+// IsAuthenticationOptional implements the OptionalAuthentication interface.
+func (a *AuthenticationMixin) IsAuthenticationOptional() bool {
+	return a.optional
+}
+
 // JSONEncoderMixin is a mixin implementing part of the Route interface.
 type JSONEncoderMixin struct {
 	// Intentionally empty.
@@ -276,14 +395,17 @@ type JSONErrorEncoderMixin struct {
 	// Intentionally empty.
 }
 
-// ErrorEncoder implements the Route interface.
-func (*JSONErrorEncoderMixin) ErrorEncoder(_ context.Context, err error, w http.ResponseWriter) {
+// ErrorEncoder implements the Route interface. It also sets the trace ID header, as after handlers do not run on errors.
+func (*JSONErrorEncoderMixin) ErrorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
 	if kitErr, ok := err.(kithttp.Error); ok {
 		err = kitErr.Err
 	}
+	if traceID := CtxTraceID(ctx); traceID != "" {
+		w.Header().Set(traceIDHeader, traceID)
+	}
 	w.Header().Add(contentTypeHeaderName, jsonContentTypeHeaderValue)
 	w.WriteHeader(ErrorToStatusCode(err))
-	_ = json.NewEncoder(w).Encode(&ErrorResponse{Error: err.Error()}) // Ignores an encoding error.
+	_ = json.NewEncoder(w).Encode(NewErrorResponse(ctx, err)) // Ignores an encoding error.
 }
 
 // JSONDecoderMixin is a mixin implementing part of the Route interface.
@@ -309,6 +431,12 @@ func (d *JSONDecoderMixin) Decoder(ctx context.Context, r *http.Request) (interf
 	return parsedBody, nil
 }
 
+// IsRequestValidated implements the ValidatedRoute interface. Decoded requests are validated by the Router validation
+// middleware, see NewValidationMiddleware.
+func (d *JSONDecoderMixin) IsRequestValidated() bool {
+	return true
+}
+
 // AdvancedRoute exposes advanced customization options that are not needed by all routes.
 type AdvancedRoute interface {
 	EnableWireMiddleware() bool
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/ConnectCorp/go-kit/kit/test"
+	"github.com/ConnectCorp/go-kit/kit/utils"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
 	"gopkg.in/ibrt/go-xerror.v2/xerror"
@@ -21,6 +22,7 @@ type testRoute struct {
 	JSONEncoderMixin
 	JSONErrorEncoderMixin
 	AdvancedRouteMixin
+	RoutePolicyMixin
 }
 
 type testRouteData struct{}
@@ -30,6 +32,7 @@ func newTestRoute(flag bool) *testRoute {
 		AuthenticationMixin: NewRequireAuthenticationMixin(),
 		MethodAndPathMixin:  NewMethodAndPathMixin("GET", "/test"),
 		AdvancedRouteMixin:  NewAdvancedRouteMixin(false, flag),
+		RoutePolicyMixin:    NewRoutePolicyMixin(NewUserPolicy()),
 	}
 }
 
@@ -63,7 +66,17 @@ func TestJSONErrorEncoderMixin(t *testing.T) {
 	response := &ErrorResponse{}
 	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), response))
 	assert.Equal(t, http.StatusNotFound, recorder.Code)
-	assert.Equal(t, &ErrorResponse{Error: err.Error()}, response)
+	assert.Equal(t, &ErrorResponse{Error: err.Error(), Code: ErrorCodeNotFound}, response)
+	assert.Equal(t, "", recorder.Header().Get(traceIDHeader))
+
+	recorder = httptest.NewRecorder()
+	err = xerror.Wrap(xerror.New("connection refused to db-1:3306"), ErrorUnexpected)
+	(&JSONErrorEncoderMixin{}).ErrorEncoder(ctxWithTraceID(context.Background(), "trace"), err, recorder)
+	response = &ErrorResponse{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), response))
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, &ErrorResponse{Error: ErrorUnexpected, Code: ErrorCodeUnexpected, TraceID: "trace"}, response)
+	assert.Equal(t, "trace", recorder.Header().Get(traceIDHeader))
 }
 
 func TestAdvancedRouteMixin(t *testing.T) {
@@ -128,6 +141,65 @@ func TestRouteWithCORS(t *testing.T) {
 	assert.Equal(t, res.StatusCode, 200)
 }
 
+func TestRouteWithOptionalAuthentication(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	token, err := ti.IssueAccessUserToken(1)
+	assert.Nil(t, err)
+
+	route := newTestRoute(false)
+	route.AuthenticationMixin = NewOptionalAuthenticationMixin()
+	route.RoutePolicyMixin = NewRoutePolicyMixin(nil)
+	assert.False(t, route.IsAuthenticated())
+	assert.True(t, route.IsAuthenticationOptional())
+
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil)
+	router.MountRoute(route)
+	ts := httptest.NewServer(router.GetMux())
+	defer ts.Close()
+
+	for authorization, expectedStatus := range map[string]int{
+		"":                http.StatusOK,
+		"Bearer " + token: http.StatusOK,
+		"Bearer bad":      http.StatusUnauthorized,
+	} {
+		req, _ := http.NewRequest("GET", ts.URL+"/v1/test", nil)
+		req.Header.Set("Authorization", authorization)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Nil(t, res.Body.Close())
+		assert.Equal(t, expectedStatus, res.StatusCode)
+	}
+}
+
+func TestJWKSRoute(t *testing.T) {
+	rootLogger := NewRootLogger(os.Stdout)
+	router := NewRouter("test", "/v1", rootLogger, nil, nil, nil, nil)
+	router.MustMountJWKSRoute([]*utils.TokenVerificationKey{{KeyID: keyID, PublicKey: publicKey}})
+
+	ts := httptest.NewServer(router.GetMux())
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/.well-known/jwks.json")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.Equal(t, "public, max-age=3600", res.Header.Get("Cache-Control"))
+
+	keySet := &utils.JSONWebKeySet{}
+	assert.Nil(t, json.NewDecoder(res.Body).Decode(keySet))
+	assert.Equal(t, 1, len(keySet.Keys))
+	assert.Equal(t, keyID, keySet.Keys[0].KeyID)
+	assert.Equal(t, "RS256", keySet.Keys[0].Algorithm)
+	assert.Equal(t, "sig", keySet.Keys[0].Use)
+
+	assert.Panics(t, func() {
+		router.MustMountJWKSRoute([]*utils.TokenVerificationKey{{KeyID: keyID, PublicKey: []byte("bad")}})
+	})
+}
+
 func TestCORSBadHeaders(t *testing.T) {
 	var handler http.Handler
 	res := httptest.NewRecorder()
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/net/context"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"reflect"
+)
+
+// ValidatedRoute is implemented by routes whose decoded requests are validated by the Router, e.g. through
+// JSONDecoderMixin.
+type ValidatedRoute interface {
+	IsRequestValidated() bool
+}
+
+// NewValidationMiddleware validates the decoded request with utils.ValidateAllFields: if fields are invalid, it returns
+// ErrorBadRequest, carrying the field errors. The Router runs it after the authorization middlewares, so that the field
+// errors of protected routes are only reported to authorized callers.
+func NewValidationMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			fieldErrors, err := utils.ValidateAllFields(reflect.ValueOf(request))
+			if err != nil {
+				return nil, err
+			}
+			if len(fieldErrors) > 0 {
+				return nil, xerror.Wrap(utils.NewInvalidFieldsError(fieldErrors), ErrorBadRequest)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+func isRequestValidated(route Route) bool {
+	validatedRoute, ok := route.(ValidatedRoute)
+	return ok && validatedRoute.IsRequestValidated()
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bytes"
+	"github.com/ConnectCorp/go-kit/kit/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+type testValidationItem struct {
+	Name string `json:"name" regexp:"^[a-z]+$"`
+}
+
+type testValidationRequest struct {
+	Kind  string               `json:"kind" enum:"a,b"`
+	Items []testValidationItem `json:"items"`
+}
+
+type testValidatedRoute struct {
+	testRoute
+	JSONDecoderMixin
+}
+
+func (r *testValidatedRoute) Decoder(ctx context.Context, req *http.Request) (interface{}, error) {
+	return r.JSONDecoderMixin.Decoder(ctx, req)
+}
+
+func TestValidationMiddleware(t *testing.T) {
+	validationFunc := NewValidationMiddleware()(func(ctx context.Context, request interface{}) (interface{}, error) {
+		return request, nil
+	})
+
+	_, err := validationFunc(context.Background(), &testValidationRequest{Kind: "a", Items: []testValidationItem{{Name: "one"}}})
+	assert.Nil(t, err)
+
+	_, err = validationFunc(context.Background(), &testValidationRequest{Kind: "c", Items: []testValidationItem{{Name: "one"}, {Name: "Two"}}})
+	assert.Equal(t, "bad request: invalid fields: kind, items[1].name", err.Error())
+	assert.Equal(t, http.StatusBadRequest, ErrorToStatusCode(err))
+	fieldErrors, ok := utils.GetFieldErrors(err)
+	assert.True(t, ok)
+	assert.Equal(t, []*utils.FieldError{{Field: "kind", Reason: utils.TagEnum}, {Field: "items[1].name", Reason: utils.TagRegexp}}, fieldErrors)
+}
+
+func TestRouterValidatesAfterAuthorization(t *testing.T) {
+	ti, err := utils.NewTokenIssuer(keyID, privateKey, issuer, audience, utils.DefaultRefreshTokenLifetime, utils.DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := utils.NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	token, err := ti.IssueAccessUserToken(1)
+	assert.Nil(t, err)
+
+	route := &testValidatedRoute{
+		testRoute: testRoute{
+			AuthenticationMixin: NewRequireAuthenticationMixin(),
+			MethodAndPathMixin:  NewMethodAndPathMixin("POST", "/validated"),
+			AdvancedRouteMixin:  NewAdvancedRouteMixin(false, false),
+			RoutePolicyMixin:    NewRoutePolicyMixin(NewUserPolicy()),
+		},
+		JSONDecoderMixin: MustNewJSONDecoderMixin(testValidationRequest{}),
+	}
+	router := NewRouter("test", "/v1", NewRootLogger(os.Stdout), tv, nil, nil, nil).MountRoute(route)
+	ts := httptest.NewServer(router.GetMux())
+	defer ts.Close()
+
+	post := func(token, body string) int {
+		req, err := http.NewRequest("POST", ts.URL+"/v1/validated", bytes.NewBufferString(body))
+		assert.Nil(t, err)
+		if token != "" {
+			req.Header.Set(authorizationHeader, "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		assert.Nil(t, res.Body.Close())
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("", `{ "kind": "c" }`))
+	assert.Equal(t, http.StatusBadRequest, post(token, `{ "kind": "c" }`))
+	assert.Equal(t, http.StatusOK, post(token, `{ "kind": "a" }`))
+}
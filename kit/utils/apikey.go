@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ErrorInvalidAPIKey is returned when an API key is malformed, unknown, revoked, or does not match.
+	ErrorInvalidAPIKey = "invalid api key"
+	// ErrorDuplicateAPIKeyID is returned when storing an API key whose ID is already in use.
+	ErrorDuplicateAPIKeyID = "duplicate api key ID: %v"
+)
+
+const (
+	// APIKeyHeader is the HTTP header carrying API keys.
+	APIKeyHeader = "X-Connect-API-Key"
+)
+
+const (
+	apiKeyIDLength     = 16
+	apiKeySecretLength = 32
+	apiKeySeparator    = "."
+)
+
+// APIKey describes a stored API key. The secret part of the key is only stored as a hash.
+type APIKey struct {
+	ID        string
+	Hash      string
+	Owner     string
+	Scopes    []string
+	CreatedAt time.Time
+	RevokedAt time.Time // Zero means not revoked.
+}
+
+// NewAPIKey generates a new API key for the given owner and scopes. It returns the key, to be handed to the owner and
+// never stored, and the APIKey to store.
+func NewAPIKey(owner string, scopes ...string) (string, *APIKey, error) {
+	if _, err := makeScopeClaims(scopes); err != nil {
+		return "", nil, err
+	}
+	id := GenRandomString(apiKeyIDLength)
+	secret := GenRandomString(apiKeySecretLength)
+	apiKey := &APIKey{
+		ID:        id,
+		Hash:      hashAPIKeySecret(id, secret),
+		Owner:     owner,
+		Scopes:    append([]string{}, scopes...),
+		CreatedAt: time.Now(),
+	}
+	return id + apiKeySeparator + secret, apiKey, nil
+}
+
+// IsRevoked returns true if the API key has been revoked.
+func (k *APIKey) IsRevoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// matches returns true if the given secret matches the stored hash.
+func (k *APIKey) matches(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(k.Hash), []byte(hashAPIKeySecret(k.ID, secret))) == 1
+}
+
+func hashAPIKeySecret(id, secret string) string {
+	return SaltAndHash(secret, id)
+}
+
+// APIKeyStore describes a storage for API keys.
+type APIKeyStore interface {
+	// StoreAPIKey stores a new API key.
+	StoreAPIKey(apiKey *APIKey) error
+	// GetAPIKey returns the API key with the given ID, or nil if not found.
+	GetAPIKey(id string) (*APIKey, error)
+	// RevokeAPIKey revokes the API key with the given ID.
+	RevokeAPIKey(id string) error
+}
+
+// VerifyAPIKey looks up the given key in the store, and returns it if it is valid and not revoked.
+func VerifyAPIKey(store APIKeyStore, key string) (*APIKey, error) {
+	parts := strings.Split(key, apiKeySeparator)
+	if len(parts) != 2 || len(parts[0]) != apiKeyIDLength || len(parts[1]) != apiKeySecretLength {
+		return nil, xerror.New(ErrorInvalidAPIKey)
+	}
+	apiKey, err := store.GetAPIKey(parts[0])
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidAPIKey)
+	}
+	if apiKey == nil || apiKey.IsRevoked() || !apiKey.matches(parts[1]) {
+		return nil, xerror.New(ErrorInvalidAPIKey, parts[0])
+	}
+	return apiKey, nil
+}
+
+type memoryAPIKeyStore struct {
+	mutex   *sync.Mutex
+	apiKeys map[string]APIKey
+}
+
+// NewMemoryAPIKeyStore initializes a new APIKeyStore that keeps API keys in memory.
+// It is only suitable for tests and single-instance services.
+func NewMemoryAPIKeyStore() APIKeyStore {
+	return &memoryAPIKeyStore{
+		mutex:   &sync.Mutex{},
+		apiKeys: make(map[string]APIKey),
+	}
+}
+
+// StoreAPIKey implements the APIKeyStore interface.
+func (s *memoryAPIKeyStore) StoreAPIKey(apiKey *APIKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.apiKeys[apiKey.ID]; ok {
+		return xerror.New(ErrorDuplicateAPIKeyID, apiKey.ID)
+	}
+	s.apiKeys[apiKey.ID] = *apiKey
+	return nil
+}
+
+// GetAPIKey implements the APIKeyStore interface.
+func (s *memoryAPIKeyStore) GetAPIKey(id string) (*APIKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	apiKey, ok := s.apiKeys[id]
+	if !ok {
+		return nil, nil
+	}
+	return &apiKey, nil
+}
+
+// RevokeAPIKey implements the APIKeyStore interface.
+func (s *memoryAPIKeyStore) RevokeAPIKey(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if apiKey, ok := s.apiKeys[id]; ok && !apiKey.IsRevoked() {
+		apiKey.RevokedAt = time.Now()
+		s.apiKeys[id] = apiKey
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+type failingAPIKeyStore struct {
+	APIKeyStore
+}
+
+func (s *failingAPIKeyStore) GetAPIKey(id string) (*APIKey, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func TestAPIKey(t *testing.T) {
+	store := NewMemoryAPIKeyStore()
+
+	key, apiKey, err := NewAPIKey("partner-a", "messages:read")
+	assert.Nil(t, err)
+	assert.Equal(t, apiKeyIDLength+len(apiKeySeparator)+apiKeySecretLength, len(key))
+	assert.True(t, strings.HasPrefix(key, apiKey.ID+apiKeySeparator))
+	assert.False(t, strings.Contains(apiKey.Hash, strings.TrimPrefix(key, apiKey.ID+apiKeySeparator)))
+	assert.Equal(t, "partner-a", apiKey.Owner)
+	assert.Equal(t, []string{"messages:read"}, apiKey.Scopes)
+	assert.False(t, apiKey.IsRevoked())
+	assert.Nil(t, store.StoreAPIKey(apiKey))
+	assert.Equal(t, "duplicate api key ID: "+apiKey.ID, store.StoreAPIKey(apiKey).Error())
+
+	verifiedAPIKey, err := VerifyAPIKey(store, key)
+	assert.Nil(t, err)
+	assert.Equal(t, apiKey, verifiedAPIKey)
+
+	otherKey, _, err := NewAPIKey("partner-a")
+	assert.Nil(t, err)
+	for _, badKey := range []string{"", "bad", key + "x", apiKey.ID + otherKey[apiKeyIDLength:], otherKey} {
+		_, err = VerifyAPIKey(store, badKey)
+		assert.Equal(t, "invalid api key", err.Error())
+	}
+	_, err = VerifyAPIKey(&failingAPIKeyStore{}, key)
+	assert.Equal(t, "invalid api key: store unavailable", err.Error())
+
+	assert.Nil(t, store.RevokeAPIKey(apiKey.ID))
+	assert.Nil(t, store.RevokeAPIKey("missing"))
+	_, err = VerifyAPIKey(store, key)
+	assert.Equal(t, "invalid api key", err.Error())
+
+	_, _, err = NewAPIKey("partner-a", "bad scope")
+	assert.Equal(t, "invalid scope: bad scope", err.Error())
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/base64"
 	"gopkg.in/ibrt/go-xerror.v2/xerror"
 	"net/url"
+	"strings"
+	"time"
 )
 
 const (
@@ -38,3 +40,63 @@ func (u *EnvURL) Decode(s string) error {
 	u.URL = decoded
 	return nil
 }
+
+// EnvBinaryMap describes a map of base-64 encoded binary environment variable values, formatted as "k1:v1,k2:v2".
+type EnvBinaryMap map[string][]byte
+
+// Decode implements the envconfig.Decoder interface.
+func (m *EnvBinaryMap) Decode(s string) error {
+	entries, err := splitEnvMap(s)
+	if err != nil {
+		return err
+	}
+	decoded := make(EnvBinaryMap, len(entries))
+	for k, v := range entries {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return xerror.Wrap(err, ErrorInvalidEnv)
+		}
+		decoded[k] = b
+	}
+	*m = decoded
+	return nil
+}
+
+// EnvTimeMap describes a map of RFC 3339 time environment variable values, formatted as "k1:v1,k2:v2".
+type EnvTimeMap map[string]time.Time
+
+// Decode implements the envconfig.Decoder interface.
+func (m *EnvTimeMap) Decode(s string) error {
+	entries, err := splitEnvMap(s)
+	if err != nil {
+		return err
+	}
+	decoded := make(EnvTimeMap, len(entries))
+	for k, v := range entries {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return xerror.Wrap(err, ErrorInvalidEnv)
+		}
+		decoded[k] = t
+	}
+	*m = decoded
+	return nil
+}
+
+func splitEnvMap(s string) (map[string]string, error) {
+	entries := make(map[string]string)
+	if strings.TrimSpace(s) == "" {
+		return entries, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, xerror.New(ErrorInvalidEnv, entry)
+		}
+		if _, ok := entries[kv[0]]; ok {
+			return nil, xerror.New(ErrorInvalidEnv, entry)
+		}
+		entries[kv[0]] = kv[1]
+	}
+	return entries, nil
+}
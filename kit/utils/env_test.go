@@ -5,6 +5,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 type testConfig struct {
@@ -22,3 +23,29 @@ func TestEnv(t *testing.T) {
 	assert.Equal(t, []byte("test"), []byte(config.Binary))
 	assert.Equal(t, "http://localhost", config.URL.URL.String())
 }
+
+type testMapConfig struct {
+	BinaryMap EnvBinaryMap `envconfig:"BINARY_MAP"`
+	TimeMap   EnvTimeMap   `envconfig:"TIME_MAP"`
+}
+
+func TestEnvMaps(t *testing.T) {
+	os.Setenv("TEST_BINARY_MAP", "k1:dGVzdA==,k2:b3RoZXI=")
+	os.Setenv("TEST_TIME_MAP", "k1:2016-01-02T15:04:05Z")
+
+	config := &testMapConfig{}
+	envconfig.MustProcess("TEST", config)
+
+	assert.Equal(t, EnvBinaryMap{"k1": []byte("test"), "k2": []byte("other")}, config.BinaryMap)
+	assert.Equal(t, EnvTimeMap{"k1": time.Date(2016, 1, 2, 15, 4, 5, 0, time.UTC)}, config.TimeMap)
+
+	m := EnvBinaryMap{}
+	assert.Nil(t, m.Decode(""))
+	assert.Equal(t, 0, len(m))
+	assert.NotNil(t, m.Decode("k1"))
+	assert.NotNil(t, m.Decode("k1:dGVzdA==,k1:dGVzdA=="))
+	assert.NotNil(t, m.Decode("k1:%%%"))
+
+	tm := EnvTimeMap{}
+	assert.NotNil(t, tm.Decode("k1:yesterday"))
+}
@@ -0,0 +1,338 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJWKSCacheLifetime is the default time after which a remote key set is refreshed.
+	DefaultJWKSCacheLifetime = time.Hour
+)
+
+const (
+	// ErrorInvalidJSONWebKey is returned when a key cannot be converted to or from the JWK format.
+	ErrorInvalidJSONWebKey = "invalid JSON web key: %v"
+	// ErrorUnableToFetchKeys is returned when a remote key set cannot be fetched.
+	ErrorUnableToFetchKeys = "unable to fetch keys"
+)
+
+const (
+	jwkKeyTypeRSA          = "RSA"
+	jwkKeyTypeEC           = "EC"
+	jwkKeyTypeOKP          = "OKP"
+	jwkCurveEd25519        = "Ed25519"
+	jwkUseSignature        = "sig"
+	jwksMinRefreshInterval = 10 * time.Second
+)
+
+// JSONWebKey describes a public key in the JWK format (RFC 7517).
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet describes a set of public keys in the JWKS format (RFC 7517).
+type JSONWebKeySet struct {
+	Keys []*JSONWebKey `json:"keys"`
+}
+
+// NewJSONWebKey converts a TokenVerificationKey to the JWK format.
+func NewJSONWebKey(key *TokenVerificationKey) (*JSONWebKey, error) {
+	tokenKey, err := parseTokenVerificationKey(key.PublicKey)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidJSONWebKey, key.KeyID)
+	}
+	jwk := &JSONWebKey{
+		KeyID:     key.KeyID,
+		Algorithm: tokenKey.method.Alg(),
+		Use:       jwkUseSignature,
+	}
+	switch publicKey := tokenKey.key.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = jwkKeyTypeRSA
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (publicKey.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = jwkKeyTypeEC
+		jwk.Curve = publicKey.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(padBytes(publicKey.X.Bytes(), size))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(padBytes(publicKey.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk.KeyType = jwkKeyTypeOKP
+		jwk.Curve = jwkCurveEd25519
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	default:
+		return nil, xerror.New(ErrorInvalidJSONWebKey, key.KeyID)
+	}
+	return jwk, nil
+}
+
+// ParsePublicKey converts the JWK to a public key usable for verifying tokens.
+func (k *JSONWebKey) ParsePublicKey() (interface{}, error) {
+	tokenKey, err := k.parseTokenKey()
+	if err != nil {
+		return nil, err
+	}
+	return tokenKey.key, nil
+}
+
+func (k *JSONWebKey) parseTokenKey() (*tokenKey, error) {
+	if k.Use != "" && k.Use != jwkUseSignature {
+		return nil, xerror.New(ErrorInvalidJSONWebKey, k.KeyID)
+	}
+
+	var publicKey interface{}
+	var err error
+
+	switch k.KeyType {
+	case jwkKeyTypeRSA:
+		publicKey, err = k.parseRSAPublicKey()
+	case jwkKeyTypeEC:
+		publicKey, err = k.parseECDSAPublicKey()
+	case jwkKeyTypeOKP:
+		publicKey, err = k.parseEd25519PublicKey()
+	default:
+		err = xerror.New(ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tokenKey, err := newTokenKey(publicKey)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	if k.Algorithm != "" && k.Algorithm != tokenKey.method.Alg() {
+		return nil, xerror.New(ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	return tokenKey, nil
+}
+
+func (k *JSONWebKey) parseRSAPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+		return nil, xerror.New(ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+func (k *JSONWebKey) parseECDSAPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+
+	switch k.Curve {
+	case elliptic.P256().Params().Name:
+		curve = elliptic.P256()
+	case elliptic.P384().Params().Name:
+		curve = elliptic.P384()
+	case elliptic.P521().Params().Name:
+		curve = elliptic.P521()
+	default:
+		return nil, xerror.New(ErrorInvalidJSONWebKey, k.KeyID)
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	if len(x) != size || len(y) != size {
+		return nil, xerror.New(ErrorInvalidJSONWebKey, k.KeyID)
+	}
+
+	publicKey := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, xerror.New(ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	return publicKey, nil
+}
+
+func (k *JSONWebKey) parseEd25519PublicKey() (ed25519.PublicKey, error) {
+	if k.Curve != jwkCurveEd25519 {
+		return nil, xerror.New(ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	if len(x) != ed25519.PublicKeySize {
+		return nil, xerror.New(ErrorInvalidJSONWebKey, k.KeyID)
+	}
+	return ed25519.PublicKey(x), nil
+}
+
+// NewJSONWebKeySet converts the TokenVerificationKeys that are not retired at the given time to the JWKS format.
+func NewJSONWebKeySet(keys []*TokenVerificationKey, now time.Time) (*JSONWebKeySet, error) {
+	keySet := &JSONWebKeySet{Keys: make([]*JSONWebKey, 0, len(keys))}
+	for _, key := range keys {
+		if key.IsRetired(now) {
+			continue
+		}
+		jwk, err := NewJSONWebKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keySet.Keys = append(keySet.Keys, jwk)
+	}
+	return keySet, nil
+}
+
+// NewRemoteTokenVerifier initializes a new TokenVerifier that fetches its keys from the JWKS published at the given URL.
+// The key set is cached for the given lifetime, and refetched early when a token references an unknown key ID.
+// If the key set cannot be refetched, the last one successfully fetched keeps being used.
+func NewRemoteTokenVerifier(jwksURL string, client *http.Client, issuer, audience string, cacheLifetime time.Duration, options ...TokenVerifierOption) (TokenVerifier, error) {
+	if err := validateIssuerAndAudience(issuer, audience); err != nil {
+		return nil, err
+	}
+	return newTokenVerifier(newJWKSTokenKeyProvider(jwksURL, client, cacheLifetime), issuer, audience, options), nil
+}
+
+type jwksTokenKeyProvider struct {
+	jwksURL            string
+	client             *http.Client
+	cacheLifetime      time.Duration
+	minRefreshInterval time.Duration
+	refreshMutex       *sync.Mutex // Serializes fetches.
+	mutex              *sync.Mutex // Protects the fields below.
+	keys               map[string]*tokenKey
+	fetchedAt          time.Time
+	attemptedAt        time.Time
+	isRefreshing       bool
+}
+
+func newJWKSTokenKeyProvider(jwksURL string, client *http.Client, cacheLifetime time.Duration) *jwksTokenKeyProvider {
+	return &jwksTokenKeyProvider{
+		jwksURL:            jwksURL,
+		client:             client,
+		cacheLifetime:      cacheLifetime,
+		minRefreshInterval: jwksMinRefreshInterval,
+		refreshMutex:       &sync.Mutex{},
+		mutex:              &sync.Mutex{},
+	}
+}
+
+func (p *jwksTokenKeyProvider) getKey(keyID string, now time.Time) (*tokenKey, error) {
+	p.mutex.Lock()
+	key, ok := p.keys[keyID]
+	isStale := p.keys != nil && now.Sub(p.fetchedAt) >= p.cacheLifetime && !p.isRefreshing
+	if isStale {
+		p.isRefreshing = true
+	}
+	p.mutex.Unlock()
+
+	if ok {
+		if isStale {
+			go func() {
+				p.maybeRefresh(now) // Keeps using the cached keys on failure.
+				p.mutex.Lock()
+				p.isRefreshing = false
+				p.mutex.Unlock()
+			}()
+		}
+		return key, nil
+	}
+
+	if err := p.maybeRefresh(now); err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidTokenHeader, keyID)
+	}
+
+	p.mutex.Lock()
+	key, ok = p.keys[keyID]
+	p.mutex.Unlock()
+
+	if !ok {
+		return nil, xerror.New(ErrorInvalidTokenHeader, keyID)
+	}
+	return key, nil
+}
+
+func (p *jwksTokenKeyProvider) maybeRefresh(now time.Time) error {
+	p.refreshMutex.Lock()
+	defer p.refreshMutex.Unlock()
+
+	p.mutex.Lock()
+	hasKeys := p.keys != nil
+	isThrottled := now.Sub(p.attemptedAt) < p.minRefreshInterval
+	p.mutex.Unlock()
+
+	if isThrottled {
+		if hasKeys {
+			return nil
+		}
+		return xerror.New(ErrorUnableToFetchKeys, p.jwksURL)
+	}
+
+	keys, err := p.fetch()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.attemptedAt = now
+	if err != nil {
+		if p.keys != nil {
+			return nil
+		}
+		return err
+	}
+	p.keys = keys
+	p.fetchedAt = p.attemptedAt
+	return nil
+}
+
+func (p *jwksTokenKeyProvider) fetch() (map[string]*tokenKey, error) {
+	resp, err := NewInboundResponse(p.client.Get(p.jwksURL))
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorUnableToFetchKeys, p.jwksURL)
+	}
+	if !resp.IsSuccessful() {
+		return nil, xerror.New(ErrorUnableToFetchKeys, p.jwksURL, resp.GetResponse().StatusCode)
+	}
+
+	keySet := &JSONWebKeySet{}
+	if err := resp.ParseJSON(keySet); err != nil {
+		return nil, xerror.Wrap(err, ErrorUnableToFetchKeys, p.jwksURL)
+	}
+
+	keys := make(map[string]*tokenKey, len(keySet.Keys))
+	for _, jwk := range keySet.Keys {
+		if key, err := jwk.parseTokenKey(); err == nil {
+			keys[jwk.KeyID] = key // Keys of unsupported types are skipped.
+		}
+	}
+	return keys, nil
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/ConnectCorp/go-kit/kit/test"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJSONWebKey(t *testing.T) {
+	jwk, err := NewJSONWebKey(&TokenVerificationKey{KeyID: keyID, PublicKey: publicKey})
+	assert.Nil(t, err)
+	assert.Equal(t, "RSA", jwk.KeyType)
+	assert.Equal(t, keyID, jwk.KeyID)
+	assert.Equal(t, "RS256", jwk.Algorithm)
+	assert.Equal(t, "sig", jwk.Use)
+	assert.Equal(t, "AQAB", jwk.E)
+
+	rsaPublicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKey)
+	assert.Nil(t, err)
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, rsaPublicKey.N.Cmp(new(big.Int).SetBytes(n)))
+
+	_, err = NewJSONWebKey(&TokenVerificationKey{KeyID: keyID, PublicKey: []byte("bad")})
+	assert.NotNil(t, err)
+}
+
+func TestJSONWebKeyWithKeyTypes(t *testing.T) {
+	jwk, err := NewJSONWebKey(&TokenVerificationKey{KeyID: keyID, PublicKey: mustMakePublicKeyPEM(ecdsaPrivateKey)})
+	assert.Nil(t, err)
+	assert.Equal(t, "EC", jwk.KeyType)
+	assert.Equal(t, "ES256", jwk.Algorithm)
+	assert.Equal(t, "P-256", jwk.Curve)
+	assert.Equal(t, 43, len(jwk.X))
+	assert.Equal(t, 43, len(jwk.Y))
+	assert.Equal(t, "", jwk.N)
+
+	ecdsaKey, err := parseTokenSigningKey(ecdsaPrivateKey)
+	assert.Nil(t, err)
+	parsedKey, err := jwk.ParsePublicKey()
+	assert.Nil(t, err)
+	assert.Equal(t, &ecdsaKey.key.(*ecdsa.PrivateKey).PublicKey, parsedKey)
+
+	jwk.Algorithm = "ES384"
+	_, err = jwk.ParsePublicKey()
+	assert.Equal(t, "invalid JSON web key: k1", err.Error())
+
+	jwk.Algorithm = "ES256"
+	jwk.Y = jwk.X
+	_, err = jwk.ParsePublicKey()
+	assert.Equal(t, "invalid JSON web key: k1", err.Error())
+
+	jwk, err = NewJSONWebKey(&TokenVerificationKey{KeyID: keyID, PublicKey: ed25519PublicKey})
+	assert.Nil(t, err)
+	assert.Equal(t, "OKP", jwk.KeyType)
+	assert.Equal(t, "EdDSA", jwk.Algorithm)
+	assert.Equal(t, "Ed25519", jwk.Curve)
+	assert.Equal(t, "", jwk.Y)
+
+	edKey, err := parseTokenVerificationKey(ed25519PublicKey)
+	assert.Nil(t, err)
+	parsedKey, err = jwk.ParsePublicKey()
+	assert.Nil(t, err)
+	assert.Equal(t, edKey.key, parsedKey)
+
+	jwk.Curve = "X25519"
+	_, err = jwk.ParsePublicKey()
+	assert.Equal(t, "invalid JSON web key: k1", err.Error())
+}
+
+func TestJSONWebKeySet(t *testing.T) {
+	now := time.Now()
+	keys := []*TokenVerificationKey{
+		{KeyID: "k1", PublicKey: publicKey, RetiresAt: now.Add(-time.Minute)},
+		{KeyID: "k2", PublicKey: mustMakePublicKeyPEM(otherPrivateKey), RetiresAt: now.Add(time.Minute)},
+		{KeyID: "k3", PublicKey: publicKey},
+	}
+
+	keySet, err := NewJSONWebKeySet(keys, now)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(keySet.Keys))
+	assert.Equal(t, "k2", keySet.Keys[0].KeyID)
+	assert.Equal(t, "k3", keySet.Keys[1].KeyID)
+
+	_, err = NewJSONWebKeySet(append(keys, &TokenVerificationKey{KeyID: "k4", PublicKey: []byte("bad")}), now)
+	assert.NotNil(t, err)
+}
+
+func TestJSONWebKeyParsePublicKey(t *testing.T) {
+	jwk, err := NewJSONWebKey(&TokenVerificationKey{KeyID: keyID, PublicKey: publicKey})
+	assert.Nil(t, err)
+
+	parsedKey, err := jwk.ParsePublicKey()
+	assert.Nil(t, err)
+	rsaPublicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKey)
+	assert.Nil(t, err)
+	assert.Equal(t, rsaPublicKey, parsedKey)
+
+	_, err = (&JSONWebKey{KeyType: "oct", KeyID: keyID}).ParsePublicKey()
+	assert.Equal(t, "invalid JSON web key: k1", err.Error())
+
+	_, err = (&JSONWebKey{KeyType: "RSA", KeyID: keyID, Use: "enc", N: jwk.N, E: jwk.E}).ParsePublicKey()
+	assert.Equal(t, "invalid JSON web key: k1", err.Error())
+
+	_, err = (&JSONWebKey{KeyType: "RSA", KeyID: keyID, N: jwk.N, E: "%"}).ParsePublicKey()
+	assert.NotNil(t, err)
+}
+
+func TestRemoteTokenVerifier(t *testing.T) {
+	otherPublicKey := mustMakePublicKeyPEM(otherPrivateKey)
+
+	ts := test.NewTempServer()
+	defer ts.Close()
+
+	var requestCount int32
+	serveKeys := func(keys ...*TokenVerificationKey) func(w http.ResponseWriter, r *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requestCount, 1)
+			keySet, err := NewJSONWebKeySet(keys, time.Now())
+			assert.Nil(t, err)
+			assert.Nil(t, json.NewEncoder(w).Encode(keySet))
+		}
+	}
+
+	k1Token, err := issueTestToken(time.Now(), "k1", tokenVersionV1, "1", TokenAccessUserRole, issuer, audience, DefaultAccessTokenLifetime, privateKey, map[string]interface{}{})
+	assert.Nil(t, err)
+	k2Token, err := issueTestToken(time.Now(), "k2", tokenVersionV1, "2", TokenAccessUserRole, issuer, audience, DefaultAccessTokenLifetime, otherPrivateKey, map[string]interface{}{})
+	assert.Nil(t, err)
+	k3Token, err := issueTestToken(time.Now(), "k3", tokenVersionV1, "3", TokenAccessUserRole, issuer, audience, DefaultAccessTokenLifetime, otherPrivateKey, map[string]interface{}{})
+	assert.Nil(t, err)
+
+	// Unreachable issuer and no cached keys.
+	ts.SetResponder(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	tv, err := NewRemoteTokenVerifier(ts.URL("/.well-known/jwks.json"), http.DefaultClient, issuer, audience, time.Hour)
+	assert.Nil(t, err)
+	keyProvider := tv.(*tokenVerifier).keyProvider.(*jwksTokenKeyProvider)
+	_, _, err = tv.VerifyToken(k1Token)
+	assert.Equal(t, "invalid token: invalid token header: unable to fetch keys", err.Error())
+
+	// First fetch.
+	keyProvider.minRefreshInterval = 0
+	ts.SetResponder(serveKeys(&TokenVerificationKey{KeyID: "k1", PublicKey: publicKey}))
+	sub, _, err := tv.VerifyToken(k1Token)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, sub)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requestCount))
+
+	// Cached keys.
+	_, _, err = tv.VerifyToken(k1Token)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requestCount))
+
+	// Unknown key ID triggers a refetch.
+	ts.SetResponder(serveKeys(&TokenVerificationKey{KeyID: "k1", PublicKey: publicKey}, &TokenVerificationKey{KeyID: "k2", PublicKey: otherPublicKey}))
+	sub, _, err = tv.VerifyToken(k2Token)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, sub)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requestCount))
+
+	// Refetches are throttled.
+	keyProvider.minRefreshInterval = time.Hour
+	_, _, err = tv.VerifyToken(k3Token)
+	assert.Equal(t, "invalid token: invalid token header", err.Error())
+	assert.EqualValues(t, 2, atomic.LoadInt32(&requestCount))
+
+	// Unreachable issuer keeps the last good key set.
+	keyProvider.minRefreshInterval = 0
+	ts.SetResponder(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requestCount, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	_, _, err = tv.VerifyToken(k3Token)
+	assert.Equal(t, "invalid token: invalid token header", err.Error())
+	assert.EqualValues(t, 3, atomic.LoadInt32(&requestCount))
+	_, _, err = tv.VerifyToken(k1Token)
+	assert.Nil(t, err)
+	_, _, err = tv.VerifyToken(k2Token)
+	assert.Nil(t, err)
+
+	// Refetches are throttled according to the verifier clock.
+	now := time.Now()
+	ts.SetResponder(serveKeys(&TokenVerificationKey{KeyID: "k1", PublicKey: publicKey}))
+	tv, err = NewRemoteTokenVerifier(ts.URL("/.well-known/jwks.json"), http.DefaultClient, issuer, audience, time.Hour, TokenVerifierClock(func() time.Time { return now }))
+	assert.Nil(t, err)
+	_, _, err = tv.VerifyToken(k1Token)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4, atomic.LoadInt32(&requestCount))
+	_, _, err = tv.VerifyToken(k2Token)
+	assert.Equal(t, "invalid token: invalid token header", err.Error())
+	assert.EqualValues(t, 4, atomic.LoadInt32(&requestCount))
+	now = now.Add(jwksMinRefreshInterval)
+	_, _, err = tv.VerifyToken(k2Token)
+	assert.Equal(t, "invalid token: invalid token header", err.Error())
+	assert.EqualValues(t, 5, atomic.LoadInt32(&requestCount))
+
+	_, err = NewRemoteTokenVerifier(ts.URL("/.well-known/jwks.json"), http.DefaultClient, "bad", audience, time.Hour)
+	assert.Equal(t, "invalid issuer: bad", err.Error())
+}
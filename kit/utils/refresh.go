@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"time"
+)
+
+const (
+	// ErrorReusedToken is returned when a refresh token is presented after it has already been exchanged.
+	ErrorReusedToken = "reused token"
+)
+
+// TokenRefresher describes the capability of exchanging refresh tokens for new access and refresh tokens.
+type TokenRefresher interface {
+	RefreshUserTokens(refreshToken string) (string, string, error)
+}
+
+type tokenRefresher struct {
+	tokenIssuer     TokenIssuer
+	tokenVerifier   TokenVerifier
+	revocationStore TokenRevocationStore
+}
+
+// NewTokenRefresher initializes a new default TokenRefresher.
+// Each refresh token can be exchanged once: the new tokens belong to the same family as the old one, and presenting an
+// already exchanged refresh token revokes the whole family. The verifier should use the same revocation store, so that
+// access tokens in a revoked family are rejected too.
+func NewTokenRefresher(tokenIssuer TokenIssuer, tokenVerifier TokenVerifier, revocationStore TokenRevocationStore) TokenRefresher {
+	return &tokenRefresher{
+		tokenIssuer:     tokenIssuer,
+		tokenVerifier:   tokenVerifier,
+		revocationStore: revocationStore,
+	}
+}
+
+// RefreshUserTokens implements the TokenRefresher interface.
+func (r *tokenRefresher) RefreshUserTokens(refreshToken string) (string, string, error) {
+	claims, err := r.tokenVerifier.VerifyTokenClaims(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	if claims.Role != TokenRefreshUserRole || claims.ID == "" {
+		return "", "", xerror.New(ErrorInvalidToken, refreshToken)
+	}
+
+	isRevoked, err := r.revocationStore.IsRevoked(claims)
+	if err != nil {
+		return "", "", xerror.Wrap(err, ErrorInvalidToken, refreshToken)
+	}
+	if isRevoked {
+		return "", "", xerror.New(ErrorRevokedToken, refreshToken)
+	}
+
+	isFirstUse, err := r.revocationStore.MarkTokenUsed(claims.ID, claims.ExpiresAt)
+	if err != nil {
+		return "", "", xerror.Wrap(err, ErrorInvalidToken, refreshToken)
+	}
+	if !isFirstUse {
+		if claims.Family != "" {
+			// Tokens issued later in the family expire at most one refresh token lifetime from now.
+			familyExpiresAt := time.Now().Add(claims.ExpiresAt.Sub(claims.IssuedAt))
+			if err := r.revocationStore.RevokeFamily(claims.Family, familyExpiresAt); err != nil {
+				return "", "", xerror.Wrap(err, ErrorReusedToken, refreshToken)
+			}
+		}
+		return "", "", xerror.New(ErrorReusedToken, refreshToken)
+	}
+
+	// Refresh tokens issued before families were introduced start a new one.
+	return r.tokenIssuer.IssueUserTokenPair(claims.Subject, claims.Family)
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRefreshUserTokens(t *testing.T) {
+	store := NewMemoryTokenRevocationStore(DefaultRefreshTokenLifetime)
+
+	ti, err := NewTokenIssuer(keyID, privateKey, issuer, audience, DefaultRefreshTokenLifetime, DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := NewTokenVerifier(keyID, publicKey, issuer, audience, TokenVerifierRevocationStore(store))
+	assert.Nil(t, err)
+	tr := NewTokenRefresher(ti, tv, store)
+
+	_, _, err = ti.IssueUserTokenPair(0, "")
+	assert.Equal(t, "invalid subject", err.Error())
+
+	uat1, urt1, err := ti.IssueUserTokenPair(1, "")
+	assert.Nil(t, err)
+	accessClaims, err := tv.VerifyTokenClaims(uat1)
+	assert.Nil(t, err)
+	refreshClaims, err := tv.VerifyTokenClaims(urt1)
+	assert.Nil(t, err)
+	assert.Equal(t, TokenAccessUserRole, accessClaims.Role)
+	assert.Equal(t, TokenRefreshUserRole, refreshClaims.Role)
+	assert.NotEqual(t, "", refreshClaims.Family)
+	assert.Equal(t, refreshClaims.Family, accessClaims.Family)
+
+	// Rotation keeps the family.
+	uat2, urt2, err := tr.RefreshUserTokens(urt1)
+	assert.Nil(t, err)
+	claims, err := tv.VerifyTokenClaims(urt2)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, claims.Subject)
+	assert.Equal(t, refreshClaims.Family, claims.Family)
+	assert.NotEqual(t, refreshClaims.ID, claims.ID)
+
+	// Access tokens cannot be exchanged.
+	_, _, err = tr.RefreshUserTokens(uat2)
+	assert.Equal(t, "invalid token", err.Error())
+
+	// Reusing a refresh token revokes the family.
+	_, _, err = tr.RefreshUserTokens(urt1)
+	assert.Equal(t, "reused token", err.Error())
+	_, _, err = tv.VerifyToken(uat1)
+	assert.Equal(t, "revoked token", err.Error())
+	_, _, err = tv.VerifyToken(uat2)
+	assert.Equal(t, "revoked token", err.Error())
+	_, _, err = tr.RefreshUserTokens(urt2)
+	assert.Equal(t, "revoked token", err.Error())
+
+	// Other families are unaffected.
+	uat3, urt3, err := ti.IssueUserTokenPair(1, "")
+	assert.Nil(t, err)
+	_, _, err = tv.VerifyToken(uat3)
+	assert.Nil(t, err)
+	_, _, err = tr.RefreshUserTokens(urt3)
+	assert.Nil(t, err)
+
+	// Refresh tokens issued outside of a family start a new one.
+	urt4, err := ti.IssueRefreshUserToken(1)
+	assert.Nil(t, err)
+	_, urt5, err := tr.RefreshUserTokens(urt4)
+	assert.Nil(t, err)
+	claims, err = tv.VerifyTokenClaims(urt5)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", claims.Family)
+	_, _, err = tr.RefreshUserTokens(urt4)
+	assert.Equal(t, "reused token", err.Error())
+
+	// Refresh tokens without an ID cannot be exchanged.
+	legacyToken, err := issueTestToken(time.Now(), keyID, tokenVersionV1, "1", TokenRefreshUserRole, issuer, audience, DefaultRefreshTokenLifetime, privateKey, map[string]interface{}{})
+	assert.Nil(t, err)
+	_, _, err = tr.RefreshUserTokens(legacyToken)
+	assert.Equal(t, "invalid token", err.Error())
+}
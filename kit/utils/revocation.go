@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenRevocationStore describes a denylist of revoked tokens, consulted by a TokenVerifier.
+type TokenRevocationStore interface {
+	// RevokeToken revokes the token with the given ID. The entry can be dropped after the token expires.
+	RevokeToken(jti string, expiresAt time.Time) error
+	// RevokeSubject revokes all the tokens for the given sub issued before the given time, unless they are already revoked
+	// up to a later time. The entry can be dropped after the max token lifetime has elapsed from the given time.
+	// Since token issue times have a precision of one second, tokens issued during the same second are revoked too.
+	RevokeSubject(sub int64, issuedBefore time.Time) error
+	// RevokeFamily revokes all the tokens in the given family. The entry can be dropped after they all expire.
+	RevokeFamily(fam string, expiresAt time.Time) error
+	// MarkTokenUsed atomically records the use of a single-use token, returning false if it was already used.
+	MarkTokenUsed(jti string, expiresAt time.Time) (bool, error)
+	// IsRevoked returns true if the token with the given claims has been revoked.
+	IsRevoked(claims *TokenClaims) (bool, error)
+}
+
+type memoryTokenRevocationStore struct {
+	mutex            *sync.Mutex
+	revokedTokens    map[string]time.Time
+	revokedSubjects  map[int64]time.Time
+	revokedFamilies  map[string]time.Time
+	usedTokens       map[string]time.Time
+	maxTokenLifetime time.Duration
+	nextPurgeAt      time.Time
+	purgeInterval    time.Duration
+}
+
+// NewMemoryTokenRevocationStore initializes a new TokenRevocationStore that keeps revocations in memory.
+// It is only suitable for tests and single-instance services. Subject revocations are kept for the given max token
+// lifetime, after which all the tokens they apply to have expired.
+func NewMemoryTokenRevocationStore(maxTokenLifetime time.Duration) TokenRevocationStore {
+	return &memoryTokenRevocationStore{
+		mutex:            &sync.Mutex{},
+		revokedTokens:    make(map[string]time.Time),
+		revokedSubjects:  make(map[int64]time.Time),
+		revokedFamilies:  make(map[string]time.Time),
+		usedTokens:       make(map[string]time.Time),
+		maxTokenLifetime: maxTokenLifetime,
+		purgeInterval:    time.Minute,
+	}
+}
+
+// RevokeToken implements the TokenRevocationStore interface.
+func (s *memoryTokenRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.maybePurge()
+	s.revokedTokens[jti] = expiresAt
+	return nil
+}
+
+// RevokeSubject implements the TokenRevocationStore interface.
+func (s *memoryTokenRevocationStore) RevokeSubject(sub int64, issuedBefore time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.maybePurge()
+	if previous, ok := s.revokedSubjects[sub]; !ok || issuedBefore.After(previous) {
+		s.revokedSubjects[sub] = issuedBefore
+	}
+	return nil
+}
+
+// RevokeFamily implements the TokenRevocationStore interface.
+func (s *memoryTokenRevocationStore) RevokeFamily(fam string, expiresAt time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.maybePurge()
+	s.revokedFamilies[fam] = expiresAt
+	return nil
+}
+
+// MarkTokenUsed implements the TokenRevocationStore interface.
+func (s *memoryTokenRevocationStore) MarkTokenUsed(jti string, expiresAt time.Time) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.maybePurge()
+	if _, ok := s.usedTokens[jti]; ok {
+		return false, nil
+	}
+	s.usedTokens[jti] = expiresAt
+	return true, nil
+}
+
+// IsRevoked implements the TokenRevocationStore interface.
+func (s *memoryTokenRevocationStore) IsRevoked(claims *TokenClaims) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if claims.ID != "" {
+		if _, ok := s.revokedTokens[claims.ID]; ok {
+			return true, nil
+		}
+	}
+	if claims.Family != "" {
+		if _, ok := s.revokedFamilies[claims.Family]; ok {
+			return true, nil
+		}
+	}
+	if issuedBefore, ok := s.revokedSubjects[claims.Subject]; ok && claims.IssuedAt.Before(issuedBefore) {
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *memoryTokenRevocationStore) maybePurge() {
+	now := time.Now()
+	if now.Before(s.nextPurgeAt) {
+		return
+	}
+	for _, entries := range []map[string]time.Time{s.revokedTokens, s.revokedFamilies, s.usedTokens} {
+		for key, expiresAt := range entries {
+			if expiresAt.Before(now) {
+				delete(entries, key)
+			}
+		}
+	}
+	for sub, issuedBefore := range s.revokedSubjects {
+		if issuedBefore.Add(s.maxTokenLifetime).Before(now) {
+			delete(s.revokedSubjects, sub)
+		}
+	}
+	s.nextPurgeAt = now.Add(s.purgeInterval)
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type failingTokenRevocationStore struct {
+	TokenRevocationStore
+}
+
+func (s *failingTokenRevocationStore) IsRevoked(claims *TokenClaims) (bool, error) {
+	return false, errors.New("store unavailable")
+}
+
+func TestMemoryTokenRevocationStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryTokenRevocationStore(DefaultRefreshTokenLifetime)
+
+	isRevoked, err := store.IsRevoked(&TokenClaims{ID: "a", Subject: 1, IssuedAt: now})
+	assert.Nil(t, err)
+	assert.False(t, isRevoked)
+
+	assert.Nil(t, store.RevokeToken("a", now.Add(time.Hour)))
+	isRevoked, err = store.IsRevoked(&TokenClaims{ID: "a", Subject: 1, IssuedAt: now})
+	assert.Nil(t, err)
+	assert.True(t, isRevoked)
+	isRevoked, err = store.IsRevoked(&TokenClaims{ID: "b", Subject: 1, IssuedAt: now})
+	assert.Nil(t, err)
+	assert.False(t, isRevoked)
+	isRevoked, err = store.IsRevoked(&TokenClaims{ID: "", Subject: 1, IssuedAt: now})
+	assert.Nil(t, err)
+	assert.False(t, isRevoked)
+
+	assert.Nil(t, store.RevokeSubject(2, now))
+	isRevoked, err = store.IsRevoked(&TokenClaims{ID: "b", Subject: 2, IssuedAt: now.Add(-time.Second)})
+	assert.Nil(t, err)
+	assert.True(t, isRevoked)
+	isRevoked, err = store.IsRevoked(&TokenClaims{ID: "b", Subject: 2, IssuedAt: now})
+	assert.Nil(t, err)
+	assert.False(t, isRevoked)
+	isRevoked, err = store.IsRevoked(&TokenClaims{ID: "b", Subject: 3, IssuedAt: now.Add(-time.Second)})
+	assert.Nil(t, err)
+	assert.False(t, isRevoked)
+	assert.Nil(t, store.RevokeSubject(2, now.Add(-time.Hour)))
+	isRevoked, err = store.IsRevoked(&TokenClaims{ID: "b", Subject: 2, IssuedAt: now.Add(-time.Second)})
+	assert.Nil(t, err)
+	assert.True(t, isRevoked)
+
+	assert.Nil(t, store.RevokeFamily("f", now.Add(time.Hour)))
+	isRevoked, err = store.IsRevoked(&TokenClaims{ID: "b", Family: "f", Subject: 3, IssuedAt: now})
+	assert.Nil(t, err)
+	assert.True(t, isRevoked)
+	isRevoked, err = store.IsRevoked(&TokenClaims{ID: "b", Family: "g", Subject: 3, IssuedAt: now})
+	assert.Nil(t, err)
+	assert.False(t, isRevoked)
+
+	isFirstUse, err := store.MarkTokenUsed("u", now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.True(t, isFirstUse)
+	isFirstUse, err = store.MarkTokenUsed("u", now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.False(t, isFirstUse)
+
+	// Expired entries are purged.
+	memoryStore := store.(*memoryTokenRevocationStore)
+	memoryStore.nextPurgeAt = time.Time{}
+	assert.Nil(t, store.RevokeToken("c", now.Add(-time.Second)))
+	assert.Equal(t, 2, len(memoryStore.revokedTokens))
+	memoryStore.nextPurgeAt = time.Time{}
+	assert.Nil(t, store.RevokeToken("d", now.Add(time.Hour)))
+	assert.Equal(t, 2, len(memoryStore.revokedTokens))
+	// Subject revocations are purged after the max token lifetime.
+	memoryStore.nextPurgeAt = time.Time{}
+	assert.Nil(t, store.RevokeSubject(4, now.Add(-DefaultRefreshTokenLifetime-time.Second)))
+	assert.Equal(t, 2, len(memoryStore.revokedSubjects))
+	memoryStore.nextPurgeAt = time.Time{}
+	assert.Nil(t, store.RevokeSubject(5, now))
+	assert.Equal(t, 2, len(memoryStore.revokedSubjects))
+	_, ok := memoryStore.revokedSubjects[4]
+	assert.False(t, ok)
+}
+
+func TestVerifyWithRevocationStore(t *testing.T) {
+	store := NewMemoryTokenRevocationStore(DefaultRefreshTokenLifetime)
+
+	ti, err := NewTokenIssuer(keyID, privateKey, issuer, audience, DefaultRefreshTokenLifetime, DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := NewTokenVerifier(keyID, publicKey, issuer, audience, TokenVerifierRevocationStore(store))
+	assert.Nil(t, err)
+
+	urt, err := ti.IssueRefreshUserToken(1)
+	assert.Nil(t, err)
+	uat, err := ti.IssueAccessUserToken(1)
+	assert.Nil(t, err)
+
+	claims, err := tv.VerifyTokenClaims(urt)
+	assert.Nil(t, err)
+	assert.Equal(t, tokenIDLength, len(claims.ID))
+	assert.EqualValues(t, 1, claims.Subject)
+	assert.Equal(t, TokenRefreshUserRole, claims.Role)
+	assert.Equal(t, DefaultRefreshTokenLifetime, claims.ExpiresAt.Sub(claims.IssuedAt))
+
+	// Single token.
+	assert.Nil(t, store.RevokeToken(claims.ID, claims.ExpiresAt))
+	_, _, err = tv.VerifyToken(urt)
+	assert.Equal(t, "revoked token", err.Error())
+	_, _, err = tv.VerifyToken(uat)
+	assert.Nil(t, err)
+
+	// All tokens for a sub.
+	otherUAT, err := ti.IssueAccessUserToken(2)
+	assert.Nil(t, err)
+	assert.Nil(t, store.RevokeSubject(1, time.Now().Add(time.Second)))
+	_, _, err = tv.VerifyToken(uat)
+	assert.Equal(t, "revoked token", err.Error())
+	_, _, err = tv.VerifyToken(otherUAT)
+	assert.Nil(t, err)
+
+	// Tokens issued before IDs were introduced.
+	legacyToken, err := issueTestToken(time.Now(), keyID, tokenVersionV1, "2", TokenAccessUserRole, issuer, audience, DefaultAccessTokenLifetime, privateKey, map[string]interface{}{})
+	assert.Nil(t, err)
+	claims, err = tv.VerifyTokenClaims(legacyToken)
+	assert.Nil(t, err)
+	assert.Equal(t, "", claims.ID)
+
+	// Single purpose tokens.
+	descriptor := NewSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, nil)
+	spt, err := ti.IssueSinglePurposeToken(descriptor, 1, map[string]interface{}{})
+	assert.Nil(t, err)
+	_, _, err = tv.VerifySinglePurposeToken(spt, descriptor)
+	assert.Equal(t, "revoked token", err.Error())
+
+	// Store failures reject the token.
+	tv, err = NewTokenVerifier(keyID, publicKey, issuer, audience, TokenVerifierRevocationStore(&failingTokenRevocationStore{}))
+	assert.Nil(t, err)
+	_, _, err = tv.VerifyToken(otherUAT)
+	assert.Equal(t, "invalid token: store unavailable", err.Error())
+}
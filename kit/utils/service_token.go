@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ErrorUnableToExchangeServiceToken is returned when a service token cannot be obtained from the auth service.
+	ErrorUnableToExchangeServiceToken = "unable to exchange service token"
+)
+
+const (
+	// DefaultServiceTokenRenewalMargin is how long before expiration a cached service token is renewed.
+	DefaultServiceTokenRenewalMargin = time.Minute
+	// ServiceTokenTargetParam is the form parameter of a service token exchange naming the target service.
+	ServiceTokenTargetParam = "target"
+	// ServiceTokenScopeParam is the form parameter of a service token exchange listing the requested scopes.
+	ServiceTokenScopeParam = "scope"
+)
+
+// ServiceTokenResponse is the response of a service token exchange, in the format of an OAuth2 token response.
+type ServiceTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// ServiceTokenSource describes the capability of providing tokens for calling another service.
+type ServiceTokenSource interface {
+	GetServiceToken() (string, error)
+}
+
+type cachedServiceTokenSource struct {
+	mutex         *sync.Mutex
+	issue         func() (string, error)
+	renewalMargin time.Duration
+	clock         func() time.Time
+	token         string
+	expiresAt     time.Time
+}
+
+// NewCachedServiceTokenSource initializes a new ServiceTokenSource that caches the tokens obtained from the given
+// function, and renews them when they are about to expire. The function usually exchanges credentials for them with
+// the auth service, see NewRemoteServiceTokenSource.
+func NewCachedServiceTokenSource(issue func() (string, error), renewalMargin time.Duration) ServiceTokenSource {
+	return &cachedServiceTokenSource{
+		mutex:         &sync.Mutex{},
+		issue:         issue,
+		renewalMargin: renewalMargin,
+		clock:         time.Now,
+	}
+}
+
+// NewRemoteServiceTokenSource initializes a new ServiceTokenSource that exchanges the API key of the calling service for
+// service tokens to call the target service, by posting to the exchange route of the auth service at the given URL.
+// Tokens are cached and renewed as with NewCachedServiceTokenSource.
+func NewRemoteServiceTokenSource(exchangeURL string, client *http.Client, apiKey, target string, renewalMargin time.Duration, scopes ...string) ServiceTokenSource {
+	return NewCachedServiceTokenSource(func() (string, error) {
+		return exchangeServiceToken(exchangeURL, client, apiKey, target, scopes)
+	}, renewalMargin)
+}
+
+func exchangeServiceToken(exchangeURL string, client *http.Client, apiKey, target string, scopes []string) (string, error) {
+	form := url.Values{}
+	form.Set(ServiceTokenTargetParam, target)
+	if len(scopes) > 0 {
+		form.Set(ServiceTokenScopeParam, strings.Join(scopes, " "))
+	}
+	req, err := http.NewRequest("POST", exchangeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", xerror.Wrap(err, ErrorUnableToExchangeServiceToken, exchangeURL)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(APIKeyHeader, apiKey)
+
+	resp, err := NewInboundResponse(client.Do(req))
+	if err != nil {
+		return "", xerror.Wrap(err, ErrorUnableToExchangeServiceToken, exchangeURL)
+	}
+	if !resp.IsSuccessful() {
+		return "", xerror.New(ErrorUnableToExchangeServiceToken, exchangeURL, resp.GetResponse().StatusCode)
+	}
+	tokenResp := &ServiceTokenResponse{}
+	if err := resp.ParseJSON(tokenResp); err != nil {
+		return "", xerror.Wrap(err, ErrorUnableToExchangeServiceToken, exchangeURL)
+	}
+	return tokenResp.AccessToken, nil
+}
+
+// GetServiceToken implements the ServiceTokenSource interface.
+func (s *cachedServiceTokenSource) GetServiceToken() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != "" && s.clock().Add(s.renewalMargin).Before(s.expiresAt) {
+		return s.token, nil
+	}
+
+	token, err := s.issue()
+	if err != nil {
+		return "", err
+	}
+	expiresAt, err := readUnverifiedTokenExpiration(token)
+	if err != nil {
+		return "", err
+	}
+
+	s.token = token
+	s.expiresAt = expiresAt
+	return token, nil
+}
+
+// readUnverifiedTokenExpiration reads the exp claim of a token without verifying it. The token must come from a trusted
+// source, since it is only used to decide when to renew it.
+func readUnverifiedTokenExpiration(t string) (time.Time, error) {
+	parts := strings.Split(t, ".")
+	if len(parts) != 3 {
+		return time.Time{}, xerror.New(ErrorInvalidToken, t)
+	}
+	payload, err := jwt.DecodeSegment(parts[1])
+	if err != nil {
+		return time.Time{}, xerror.Wrap(err, ErrorInvalidToken, t)
+	}
+
+	claims := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return time.Time{}, xerror.Wrap(err, ErrorInvalidToken, t)
+	}
+	exp, ok := claims[expirationHeader].(json.Number)
+	if !ok {
+		return time.Time{}, xerror.New(ErrorInvalidToken, t)
+	}
+	expInt64, err := exp.Int64()
+	if err != nil {
+		return time.Time{}, xerror.Wrap(err, ErrorInvalidToken, t)
+	}
+	return time.Unix(expInt64, 0), nil
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"testing"
+	"time"
+)
+
+func TestCachedServiceTokenSource(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	ti, err := NewTokenIssuer(keyID, privateKey, issuer, audience, DefaultRefreshTokenLifetime, DefaultAccessTokenLifetime, TokenIssuerClock(clock))
+	assert.Nil(t, err)
+
+	issueCount := 0
+	var issueErr error
+	source := NewCachedServiceTokenSource(func() (string, error) {
+		issueCount++
+		if issueErr != nil {
+			return "", issueErr
+		}
+		return ti.IssueServiceToken("messages", "notifications")
+	}, DefaultServiceTokenRenewalMargin)
+	source.(*cachedServiceTokenSource).clock = clock
+
+	st1, err := source.GetServiceToken()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, issueCount)
+
+	now = now.Add(DefaultServiceTokenLifetime - DefaultServiceTokenRenewalMargin - time.Second)
+	st2, err := source.GetServiceToken()
+	assert.Nil(t, err)
+	assert.Equal(t, st1, st2)
+	assert.Equal(t, 1, issueCount)
+
+	now = now.Add(time.Second)
+	st3, err := source.GetServiceToken()
+	assert.Nil(t, err)
+	assert.NotEqual(t, st1, st3)
+	assert.Equal(t, 2, issueCount)
+
+	now = now.Add(DefaultServiceTokenLifetime)
+	issueErr = xerror.New("issue error")
+	_, err = source.GetServiceToken()
+	assert.Equal(t, "issue error", err.Error())
+
+	source = NewCachedServiceTokenSource(func() (string, error) { return "bad", nil }, DefaultServiceTokenRenewalMargin)
+	_, err = source.GetServiceToken()
+	assert.Equal(t, "invalid token", err.Error())
+}
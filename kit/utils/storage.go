@@ -6,11 +6,8 @@ import (
 	"github.com/guregu/null"
 	"github.com/jmoiron/sqlx"
 	"gopkg.in/ibrt/go-xerror.v2/xerror"
-	"net/url"
 	"reflect"
-	"regexp"
 	"strings"
-	"unicode/utf8"
 )
 
 const (
@@ -102,50 +99,8 @@ func ValidateFields(v reflect.Value) error {
 		v = v.Elem()
 	}
 	for i := 0; i < v.NumField(); i++ {
-		f := v.Type().Field(i)
-		s, ok := maybeGetStringValue(v.Field(i).Interface())
-		regexpTag := f.Tag.Get(TagRegexp)
-		urlTag := f.Tag.Get(TagURL)
-		enumTag := f.Tag.Get(TagEnum)
-
-		if ok {
-			if s != nil {
-				if !utf8.ValidString(*s) {
-					return xerror.New(ErrorInvalidUTF8Encoding, f.Name, *s)
-				}
-				if regexpTag != "" {
-					r, err := regexp.Compile(regexpTag)
-					if err != nil {
-						return xerror.Wrap(err, ErrorInvalidRegexpTag, f.Name, regexpTag)
-					}
-					if s != nil && !r.MatchString(*s) {
-						return xerror.New(ErrorInvalidFieldValue, *s, f.Name)
-					}
-				}
-				if urlTag != "" {
-					if *s == "" {
-						return xerror.New(ErrorInvalidFieldValue, *s, f.Name)
-					}
-					if _, err := url.Parse(*s); err != nil {
-						return xerror.Wrap(err, ErrorInvalidFieldValue, *s, f.Name)
-					}
-				}
-				if enumTag != "" {
-					if !checkEnum(*s, strings.Split(enumTag, ",")) {
-						return xerror.New(ErrorInvalidFieldValue, *s, f.Name)
-					}
-				}
-			}
-		} else {
-			if regexpTag != "" {
-				return xerror.New(ErrorInvalidFieldTypeForRegexpTag, f.Type, f.Name)
-			}
-			if urlTag != "" {
-				return xerror.New(ErrorInvalidFieldTypeForURLTag, f.Type, f.Name)
-			}
-			if enumTag != "" {
-				return xerror.New(ErrorInvalidFieldTypeForEnumTag, f.Type, f.Name)
-			}
+		if _, err := validateField(v.Type().Field(i), v.Field(i)); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -2,12 +2,10 @@ package utils
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"gopkg.in/ibrt/go-xerror.v2/xerror"
 	"reflect"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -19,11 +17,17 @@ const (
 	DefaultRefreshTokenLifetime = time.Hour * 24 * 365
 	// DefaultSinglePurposeTokenLifetime is the default lifetime for a single purpose token.
 	DefaultSinglePurposeTokenLifetime = time.Hour
+	// DefaultServiceTokenLifetime is the default lifetime for a service token.
+	DefaultServiceTokenLifetime = time.Minute * 15
 )
 
 const (
 	// ErrorInvalidKeyID is returned when an invalid key ID is provided.
 	ErrorInvalidKeyID = "invalid key ID: %v"
+	// ErrorDuplicateKeyID is returned when the same key ID is provided more than once.
+	ErrorDuplicateKeyID = "duplicate key ID: %v"
+	// ErrorMissingKeys is returned when no keys are provided.
+	ErrorMissingKeys = "missing keys"
 	// ErrorInvalidKeyMaterial is returned when invalid key material is provided.
 	ErrorInvalidKeyMaterial = "invalid key material"
 	// ErrorInvalidIssuer is returned when an invalid issuer is provided.
@@ -36,12 +40,22 @@ const (
 	ErrorInvalidSubject = "invalid subject"
 	// ErrorInvalidRole is returned when an invalid role is provided.
 	ErrorInvalidRole = "invalid role"
+	// ErrorInvalidActor is returned when an invalid actor is provided.
+	ErrorInvalidActor = "invalid actor: %v"
+	// ErrorInvalidScope is returned when an invalid scope is provided.
+	ErrorInvalidScope = "invalid scope: %v"
+	// ErrorInvalidServiceName is returned when an invalid client or target service name is provided.
+	ErrorInvalidServiceName = "invalid service name: %v"
+	// ErrorInvalidServiceTarget is returned when the target of a service token is a shared audience instead of a service.
+	ErrorInvalidServiceTarget = "invalid service target: %v"
 	// ErrorInvalidToken is returned when validation fails due to an invalid token.
 	ErrorInvalidToken = "invalid token"
 	// ErrorInvalidTokenHeader  is returned when validation fails due to an invalid token header.
 	ErrorInvalidTokenHeader = "invalid token header"
 	// ErrorExpiredToken is returned when validation fails due to an expired token.
 	ErrorExpiredToken = "expired token"
+	// ErrorRevokedToken is returned when validation fails due to a revoked token.
+	ErrorRevokedToken = "revoked token"
 	// ErrorInvalidCustomClaim is returned when an invalid custom claim is provided.
 	ErrorInvalidCustomClaim = "invalid custom claim: %v"
 	// ErrorMissingCustomClaims is returned when some custom claims are missing.
@@ -58,7 +72,8 @@ const (
 )
 
 const (
-	currentTokenVersion = "v1"
+	errorExpiredBy      = "token is expired by %v"
+	errorNotValidYet    = "token is not valid yet"
 	defaultSystemUserID = 0
 	keyIDHeader         = "kid"
 	tokenVersionHeader  = "v"
@@ -67,15 +82,40 @@ const (
 	issuerHeader        = "iss"
 	audienceHeader      = "aud"
 	expirationHeader    = "exp"
+	notBeforeHeader     = "nbf"
 	issuedAtHeader      = "iat"
+	tokenIDHeader       = "jti"
+	tokenIDLength       = 24
+	familyHeader        = "fam"
+	scopeHeader         = "scope"
+	actorHeader         = "act"
+	clientHeader        = "client_id"
 )
 
+var keyIDRegexp = regexp.MustCompile("^k[0-9]+$")
+
+var scopeRegexp = regexp.MustCompile(`^[\x21\x23-\x5B\x5D-\x7E]+$`) // As defined in RFC 6749, section 3.3.
+
+var serviceNameRegexp = regexp.MustCompile("^[a-z0-9]+(-[a-z0-9]+)*$")
+
 // SinglePurposeTokenDescriptor describes the settings for issuing and verifying a single purpose token.
 type SinglePurposeTokenDescriptor interface {
 	GetRole() string
 	IsSubMeaningful() bool
 	GetLifetime() time.Duration
 	GetAllowedCustomClaims() map[string]reflect.Type
+	IsEncrypted() bool
+}
+
+// SinglePurposeTokenDescriptorOption configures optional SinglePurposeTokenDescriptor behaviors.
+type SinglePurposeTokenDescriptorOption func(*singlePurposeTokenDescriptor)
+
+// SinglePurposeTokenEncrypted makes tokens for the descriptor encrypted after signing, so that their claims cannot be
+// read by their bearer. Both the TokenIssuer and the TokenVerifier must be configured with the encryption keys.
+func SinglePurposeTokenEncrypted() SinglePurposeTokenDescriptorOption {
+	return func(s *singlePurposeTokenDescriptor) {
+		s.isEncrypted = true
+	}
 }
 
 type singlePurposeTokenDescriptor struct {
@@ -83,16 +123,22 @@ type singlePurposeTokenDescriptor struct {
 	isSubMeaningful     bool
 	lifetime            time.Duration
 	allowedCustomClaims map[string]reflect.Type
+	isEncrypted         bool
 }
 
-// NewSinglePurposeTokenDescriptor initializes a new SinglePurposeTokenDescriptor.
-func NewSinglePurposeTokenDescriptor(role string, isSubMeaningful bool, lifetime time.Duration, allowedCustomClaims map[string]reflect.Type) SinglePurposeTokenDescriptor {
-	return &singlePurposeTokenDescriptor{
+// NewSinglePurposeTokenDescriptor initializes a new SinglePurposeTokenDescriptor. Custom claims cannot be named after the
+// standard claims, e.g. "role" or "aud": tokens for a descriptor allowing them can be neither issued nor verified.
+func NewSinglePurposeTokenDescriptor(role string, isSubMeaningful bool, lifetime time.Duration, allowedCustomClaims map[string]reflect.Type, options ...SinglePurposeTokenDescriptorOption) SinglePurposeTokenDescriptor {
+	s := &singlePurposeTokenDescriptor{
 		role:                role,
 		isSubMeaningful:     isSubMeaningful,
 		lifetime:            lifetime,
 		allowedCustomClaims: allowedCustomClaims,
 	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
 }
 
 // GetRole implements the SinglePurposeTokenDescriptor interface.
@@ -115,48 +161,179 @@ func (s *singlePurposeTokenDescriptor) GetAllowedCustomClaims() map[string]refle
 	return s.allowedCustomClaims
 }
 
+// IsEncrypted implements the SinglePurposeTokenDescriptor interface.
+func (s *singlePurposeTokenDescriptor) IsEncrypted() bool {
+	return s.isEncrypted
+}
+
 // TokenIssuer describes the capability of issuing tokens.
 type TokenIssuer interface {
 	IssueAccessUserToken(sub int64) (string, error)
 	IssueRefreshUserToken(sub int64) (string, error)
 	IssueAccessSystemToken() (string, error)
+	IssueScopedAccessUserToken(sub int64, scopes ...string) (string, error)
+	IssueScopedAccessSystemToken(scopes ...string) (string, error)
+	IssueDelegatedAccessUserToken(sub, actorSub int64, scopes ...string) (string, error)
+	IssueServiceToken(client, target string, scopes ...string) (string, error)
 	IssueSinglePurposeToken(descriptor SinglePurposeTokenDescriptor, sub int64, customClaims map[string]interface{}) (string, error)
+	IssueTypedSinglePurposeToken(descriptor TypedSinglePurposeTokenDescriptor, sub int64, claims interface{}) (string, error)
+	IssueUserTokenPair(sub int64, fam string) (string, string, error)
+}
+
+// TokenIssuerOption configures optional TokenIssuer behaviors.
+type TokenIssuerOption func(*tokenIssuer)
+
+// TokenIssuerFormat makes the TokenIssuer emit tokens in the given format, instead of DefaultTokenFormat.
+// It can be used to keep emitting an older format while verifiers are being upgraded to accept the newer one.
+func TokenIssuerFormat(format TokenFormat) TokenIssuerOption {
+	return func(ti *tokenIssuer) {
+		ti.format = format
+	}
+}
+
+// TokenIssuerServiceTokenLifetime makes the TokenIssuer use the given lifetime for service tokens, instead of
+// DefaultServiceTokenLifetime.
+func TokenIssuerServiceTokenLifetime(lifetime time.Duration) TokenIssuerOption {
+	return func(ti *tokenIssuer) {
+		ti.serviceLifetime = lifetime
+	}
+}
+
+// TokenIssuerEncryptionKey makes the TokenIssuer encrypt tokens for encrypted descriptors with the given AES-256 key.
+func TokenIssuerEncryptionKey(keyID string, key []byte) TokenIssuerOption {
+	return func(ti *tokenIssuer) {
+		ti.encryptionKeyID = keyID
+		ti.encryptionKey = key
+	}
+}
+
+// TokenIssuerClock makes the TokenIssuer use the given clock instead of time.Now.
+func TokenIssuerClock(clock func() time.Time) TokenIssuerOption {
+	return func(ti *tokenIssuer) {
+		ti.clock = clock
+	}
 }
 
 type tokenIssuer struct {
 	keyID           string
-	privateKey      []byte
+	signingKey      *tokenKey
 	issuer          string
 	audience        string
 	refreshLifetime time.Duration
 	accessLifetime  time.Duration
+	serviceLifetime time.Duration
+	clock           func() time.Time
+	format          TokenFormat
+	encryptionKeyID string
+	encryptionKey   []byte
 }
 
 // NewTokenIssuer initializes a new default TokenIssuer.
-func NewTokenIssuer(keyID string, privateKey []byte, issuer, audience string, refreshLifetime, accessLifetime time.Duration) (TokenIssuer, error) {
+// The signing algorithm is determined by the private key: RS256 for RSA, ES256/ES384/ES512 for ECDSA on the P-256/P-384/P-521
+// curves, and EdDSA for Ed25519.
+func NewTokenIssuer(keyID string, privateKey []byte, issuer, audience string, refreshLifetime, accessLifetime time.Duration, options ...TokenIssuerOption) (TokenIssuer, error) {
 	if err := validateParams(keyID, privateKey, issuer, audience); err != nil {
 		return nil, err
 	}
-	return &tokenIssuer{
+	signingKey, err := parseTokenSigningKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	ti := &tokenIssuer{
 		keyID:           keyID,
-		privateKey:      privateKey,
+		signingKey:      signingKey,
 		issuer:          issuer,
 		audience:        audience,
 		refreshLifetime: refreshLifetime,
 		accessLifetime:  accessLifetime,
-	}, nil
+		serviceLifetime: DefaultServiceTokenLifetime,
+		clock:           time.Now,
+		format:          DefaultTokenFormat,
+	}
+	for _, option := range options {
+		option(ti)
+	}
+	return ti, nil
 }
 
 func (ti *tokenIssuer) IssueAccessUserToken(sub int64) (string, error) {
-	return ti.issueAuthenticationToken(sub, TokenAccessUserRole)
+	return ti.issueAuthenticationToken(sub, TokenAccessUserRole, nil, nil)
 }
 
 func (ti *tokenIssuer) IssueRefreshUserToken(sub int64) (string, error) {
-	return ti.issueAuthenticationToken(sub, TokenRefreshUserRole)
+	return ti.issueAuthenticationToken(sub, TokenRefreshUserRole, nil, nil)
 }
 
 func (ti *tokenIssuer) IssueAccessSystemToken() (string, error) {
-	return ti.issueAuthenticationToken(defaultSystemUserID, TokenAccessSystemRole)
+	return ti.issueAuthenticationToken(defaultSystemUserID, TokenAccessSystemRole, nil, nil)
+}
+
+// IssueScopedAccessUserToken issues an access user token carrying the given scopes. Scopes are advisory: they are only
+// enforced by verifiers that require them, e.g. service.AuthVerifier.RequireScopes, and other checks ignore them.
+func (ti *tokenIssuer) IssueScopedAccessUserToken(sub int64, scopes ...string) (string, error) {
+	return ti.issueAuthenticationToken(sub, TokenAccessUserRole, scopes, nil)
+}
+
+// IssueScopedAccessSystemToken issues an access system token carrying the given scopes. Scopes are advisory: they are only
+// enforced by verifiers that require them, e.g. service.AuthVerifier.RequireScopes, and other checks ignore them.
+func (ti *tokenIssuer) IssueScopedAccessSystemToken(scopes ...string) (string, error) {
+	return ti.issueAuthenticationToken(defaultSystemUserID, TokenAccessSystemRole, scopes, nil)
+}
+
+// IssueDelegatedAccessUserToken issues an access user token for sub, used on their behalf by actorSub (RFC 8693).
+func (ti *tokenIssuer) IssueDelegatedAccessUserToken(sub, actorSub int64, scopes ...string) (string, error) {
+	if actorSub <= 0 || actorSub == sub {
+		return "", xerror.New(ErrorInvalidActor, actorSub)
+	}
+	return ti.issueAuthenticationToken(sub, TokenAccessUserRole, scopes, map[string]interface{}{
+		actorHeader: map[string]interface{}{subjectHeader: ti.format.EncodeSubject(actorSub)},
+	})
+}
+
+// IssueServiceToken issues an access system token naming the calling service, only valid for the target service.
+// Unlike other tokens, its audience is the target service instead of the shared audience. Since a TokenIssuer can mint
+// tokens for any client, it must only be called by the auth service, e.g. with service.ServiceTokenExchangeRoute.
+// Other services obtain service tokens with NewRemoteServiceTokenSource. The target cannot be a shared audience, since
+// every service accepts system tokens for it.
+func (ti *tokenIssuer) IssueServiceToken(client, target string, scopes ...string) (string, error) {
+	if err := validateServiceName(client); err != nil {
+		return "", err
+	}
+	if err := validateServiceName(target); err != nil {
+		return "", err
+	}
+	if target == ti.audience || validateAudience(target) == nil {
+		return "", xerror.New(ErrorInvalidServiceTarget, target)
+	}
+	claims, err := makeScopeClaims(scopes)
+	if err != nil {
+		return "", err
+	}
+	claims[clientHeader] = client
+	claims[audienceHeader] = []string{target}
+
+	return ti.issueLowLevelToken(defaultSystemUserID, TokenAccessSystemRole, ti.serviceLifetime, claims)
+}
+
+// IssueUserTokenPair issues an access and a refresh token belonging to the given family, or to a new one if empty.
+func (ti *tokenIssuer) IssueUserTokenPair(sub int64, fam string) (string, string, error) {
+	if sub <= 0 {
+		return "", "", xerror.New(ErrorInvalidSubject, sub)
+	}
+	if fam == "" {
+		fam = GenRandomString(tokenIDLength)
+	}
+	familyClaims := map[string]interface{}{familyHeader: fam}
+
+	accessToken, err := ti.issueLowLevelToken(sub, TokenAccessUserRole, ti.accessLifetime, familyClaims)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := ti.issueLowLevelToken(sub, TokenRefreshUserRole, ti.refreshLifetime, familyClaims)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
 }
 
 func (ti *tokenIssuer) IssueSinglePurposeToken(descriptor SinglePurposeTokenDescriptor, sub int64, customClaims map[string]interface{}) (string, error) {
@@ -166,14 +343,30 @@ func (ti *tokenIssuer) IssueSinglePurposeToken(descriptor SinglePurposeTokenDesc
 	if !descriptor.IsSubMeaningful() && sub != 0 {
 		return "", xerror.New(ErrorInvalidSubject, sub)
 	}
-	if err := validateCustomClaims(descriptor.GetAllowedCustomClaims(), customClaims); err != nil {
+	if err := validateDescriptorCustomClaims(descriptor, customClaims); err != nil {
 		return "", err
 	}
+	isEncrypted := descriptor.IsEncrypted()
+	if isEncrypted && ti.encryptionKey == nil {
+		return "", xerror.New(ErrorMissingEncryptionKey)
+	}
+
+	t, err := ti.issueLowLevelToken(sub, descriptor.GetRole(), descriptor.GetLifetime(), customClaims)
+	if err != nil || !isEncrypted {
+		return t, err
+	}
+	return encryptToken(ti.encryptionKeyID, ti.encryptionKey, t)
+}
 
-	return ti.issueLowLevelToken(sub, descriptor.GetRole(), descriptor.GetLifetime(), customClaims)
+func (ti *tokenIssuer) IssueTypedSinglePurposeToken(descriptor TypedSinglePurposeTokenDescriptor, sub int64, claims interface{}) (string, error) {
+	customClaims, err := descriptor.EncodeClaims(claims)
+	if err != nil {
+		return "", err
+	}
+	return ti.IssueSinglePurposeToken(descriptor, sub, customClaims)
 }
 
-func (ti *tokenIssuer) issueAuthenticationToken(sub int64, role string) (string, error) {
+func (ti *tokenIssuer) issueAuthenticationToken(sub int64, role string, scopes []string, claims map[string]interface{}) (string, error) {
 	if role != TokenAccessUserRole && role != TokenAccessSystemRole && role != TokenRefreshUserRole {
 		return "", xerror.New(ErrorInvalidRole, sub)
 	}
@@ -192,134 +385,361 @@ func (ti *tokenIssuer) issueAuthenticationToken(sub int64, role string) (string,
 		lifetime = ti.accessLifetime
 	}
 
-	return ti.issueLowLevelToken(sub, role, lifetime, map[string]interface{}{})
+	scopeClaims, err := makeScopeClaims(scopes)
+	if err != nil {
+		return "", err
+	}
+	for claim, value := range claims {
+		scopeClaims[claim] = value
+	}
+
+	return ti.issueLowLevelToken(sub, role, lifetime, scopeClaims)
+}
+
+func makeScopeClaims(scopes []string) (map[string]interface{}, error) {
+	claims := map[string]interface{}{}
+	if len(scopes) > 0 {
+		for _, scope := range scopes {
+			if !scopeRegexp.MatchString(scope) {
+				return nil, xerror.New(ErrorInvalidScope, scope)
+			}
+		}
+		claims[scopeHeader] = strings.Join(scopes, " ")
+	}
+	return claims, nil
 }
 
 func (ti *tokenIssuer) issueLowLevelToken(sub int64, role string, lifetime time.Duration, customClaims map[string]interface{}) (string, error) {
-	issuedAt := time.Now()
-	t := jwt.New(jwt.SigningMethodRS256)
+	issuedAt := ti.clock()
+	t := jwt.New(ti.signingKey.method)
 
 	t.Header[keyIDHeader] = ti.keyID
-	t.Claims[tokenVersionHeader] = currentTokenVersion
-	t.Claims[subjectHeader] = fmt.Sprintf("%v", sub)
+	t.Claims[tokenVersionHeader] = ti.format.GetVersion()
+	t.Claims[subjectHeader] = ti.format.EncodeSubject(sub)
 	t.Claims[roleHeader] = role
 	t.Claims[issuerHeader] = ti.issuer
 	t.Claims[audienceHeader] = ti.audience
 	t.Claims[issuedAtHeader] = issuedAt.Unix()
 	t.Claims[expirationHeader] = issuedAt.Add(lifetime).Unix()
+	t.Claims[tokenIDHeader] = GenRandomString(tokenIDLength)
 
 	for claim, value := range customClaims {
 		t.Claims[claim] = value
 	}
 
-	s, err := t.SignedString(ti.privateKey)
+	s, err := t.SignedString(ti.signingKey.key)
 	if err != nil {
 		return "", xerror.Wrap(err, ErrorUnableToSignToken, t)
 	}
 	return s, nil
 }
 
+// TokenClaims describes the claims of a verified token.
+type TokenClaims struct {
+	ID        string      // Empty for tokens issued before IDs were introduced.
+	Family    string      // Empty for tokens not issued as part of a refresh token family.
+	Scopes    []string    // Empty for unscoped tokens.
+	Actor     *TokenActor // Nil for tokens that are not delegated.
+	Client    string      // Empty for tokens not issued to a service.
+	Audience  []string
+	Subject   int64
+	Role      string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// TokenActor describes the party acting on behalf of the subject of a delegated token.
+type TokenActor struct {
+	Subject int64
+}
+
 // TokenVerifier describes the capability of verifying tokens.
 type TokenVerifier interface {
 	VerifyToken(t string) (int64, string, error)
+	VerifyTokenClaims(t string) (*TokenClaims, error)
 	VerifySinglePurposeToken(t string, descriptor SinglePurposeTokenDescriptor) (int64, map[string]interface{}, error)
+	VerifySinglePurposeTokenClaims(t string, descriptor SinglePurposeTokenDescriptor) (*TokenClaims, map[string]interface{}, error)
+	VerifyTypedSinglePurposeToken(t string, descriptor TypedSinglePurposeTokenDescriptor, claims interface{}) (*TokenClaims, error)
+}
+
+// TokenVerifierOption configures optional TokenVerifier behaviors.
+type TokenVerifierOption func(*tokenVerifier)
+
+// TokenVerifierClock makes the TokenVerifier use the given clock instead of time.Now.
+func TokenVerifierClock(clock func() time.Time) TokenVerifierOption {
+	return func(tv *tokenVerifier) {
+		tv.clock = clock
+	}
+}
+
+// TokenVerifierLeeway makes the TokenVerifier tolerate the given clock skew when checking the "exp" and "nbf" claims.
+func TokenVerifierLeeway(leeway time.Duration) TokenVerifierOption {
+	return func(tv *tokenVerifier) {
+		tv.leeway = leeway
+	}
+}
+
+// TokenVerifierFormats makes the TokenVerifier only accept tokens in the given formats, instead of DefaultTokenFormats.
+func TokenVerifierFormats(formats ...TokenFormat) TokenVerifierOption {
+	return func(tv *tokenVerifier) {
+		tv.formats = makeTokenFormatsMap(formats)
+	}
+}
+
+// TokenVerifierServiceAudience makes the TokenVerifier also accept tokens whose audience includes the given service, as
+// issued by TokenIssuer.IssueServiceToken. If required, system tokens are only accepted if issued for the service.
+func TokenVerifierServiceAudience(service string, required bool) TokenVerifierOption {
+	return func(tv *tokenVerifier) {
+		tv.serviceAudience = service
+		tv.requireServiceAudience = required
+	}
+}
+
+// TokenVerifierEncryptionKeys makes the TokenVerifier decrypt tokens for encrypted descriptors, using the AES-256 keys
+// given by key ID.
+func TokenVerifierEncryptionKeys(keys map[string][]byte) TokenVerifierOption {
+	return func(tv *tokenVerifier) {
+		tv.encryptionKeys = keys
+	}
+}
+
+// TokenVerifierRevocationStore rejects tokens that have been revoked in the given store.
+func TokenVerifierRevocationStore(revocationStore TokenRevocationStore) TokenVerifierOption {
+	return func(tv *tokenVerifier) {
+		tv.revocationStore = revocationStore
+	}
+}
+
+// TokenVerificationKey describes a public key accepted by a TokenVerifier.
+type TokenVerificationKey struct {
+	KeyID     string
+	PublicKey []byte
+	RetiresAt time.Time // Tokens signed with this key are rejected from this time on. Zero means never.
+}
+
+// IsRetired returns true if the key must no longer be used for verifying tokens at the given time.
+func (k *TokenVerificationKey) IsRetired(now time.Time) bool {
+	return !k.RetiresAt.IsZero() && !now.Before(k.RetiresAt)
+}
+
+// tokenKeyProvider resolves the key used to verify tokens signed with the given key ID, at the given time.
+type tokenKeyProvider interface {
+	getKey(keyID string, now time.Time) (*tokenKey, error)
+}
+
+type staticTokenKey struct {
+	verificationKey *TokenVerificationKey
+	tokenKey        *tokenKey
+}
+
+type staticTokenKeyProvider map[string]*staticTokenKey
+
+func (p staticTokenKeyProvider) getKey(keyID string, now time.Time) (*tokenKey, error) {
+	key, ok := p[keyID]
+	if !ok || key.verificationKey.IsRetired(now) {
+		return nil, xerror.New(ErrorInvalidTokenHeader, keyID)
+	}
+	return key.tokenKey, nil
 }
 
 type tokenVerifier struct {
-	keyID     string
-	publicKey []byte
-	issuer    string
-	audience  string
-	jwtParser *jwt.Parser
+	keyProvider     tokenKeyProvider
+	issuer          string
+	audience        string
+	serviceAudience string
+	jwtParser       *jwt.Parser
+	revocationStore TokenRevocationStore
+	clock           func() time.Time
+	leeway          time.Duration
+	formats         map[string]TokenFormat
+	encryptionKeys  map[string][]byte
+	cache           *tokenCache
+
+	requireServiceAudience bool
 }
 
 // NewTokenVerifier initializes a new default TokenVerifier.
-func NewTokenVerifier(keyID string, publicKey []byte, issuer, audience string) (TokenVerifier, error) {
-	if err := validateParams(keyID, publicKey, issuer, audience); err != nil {
-		return nil, err
+func NewTokenVerifier(keyID string, publicKey []byte, issuer, audience string, options ...TokenVerifierOption) (TokenVerifier, error) {
+	return NewMultiKeyTokenVerifier([]*TokenVerificationKey{{KeyID: keyID, PublicKey: publicKey}}, issuer, audience, options...)
+}
+
+// NewMultiKeyTokenVerifier initializes a new default TokenVerifier that accepts tokens signed with any of the given keys.
+// Each key only accepts tokens signed with the algorithm determined by its type, as for NewTokenIssuer.
+func NewMultiKeyTokenVerifier(keys []*TokenVerificationKey, issuer, audience string, options ...TokenVerifierOption) (TokenVerifier, error) {
+	if len(keys) == 0 {
+		return nil, xerror.New(ErrorMissingKeys)
 	}
-	return &tokenVerifier{
-		keyID:     keyID,
-		publicKey: publicKey,
-		issuer:    issuer,
-		audience:  audience,
-		jwtParser: &jwt.Parser{UseJSONNumber: true},
-	}, nil
+	keysByID := make(staticTokenKeyProvider, len(keys))
+	for _, key := range keys {
+		if err := validateParams(key.KeyID, key.PublicKey, issuer, audience); err != nil {
+			return nil, err
+		}
+		if _, ok := keysByID[key.KeyID]; ok {
+			return nil, xerror.New(ErrorDuplicateKeyID, key.KeyID)
+		}
+		tokenKey, err := parseTokenVerificationKey(key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		keysByID[key.KeyID] = &staticTokenKey{verificationKey: key, tokenKey: tokenKey}
+	}
+	return newTokenVerifier(keysByID, issuer, audience, options), nil
+}
+
+func newTokenVerifier(keyProvider tokenKeyProvider, issuer, audience string, options []TokenVerifierOption) *tokenVerifier {
+	tv := &tokenVerifier{
+		keyProvider: keyProvider,
+		issuer:      issuer,
+		audience:    audience,
+		jwtParser:   &jwt.Parser{UseJSONNumber: true},
+		clock:       time.Now,
+		formats:     makeTokenFormatsMap(DefaultTokenFormats),
+	}
+	for _, option := range options {
+		option(tv)
+	}
+	return tv
 }
 
 func (tv *tokenVerifier) VerifyToken(t string) (int64, string, error) {
-	dt, sub, role, err := tv.preVerify(t)
+	claims, err := tv.VerifyTokenClaims(t)
 	if err != nil {
 		return 0, "", err
 	}
+	return claims.Subject, claims.Role, nil
+}
+
+func (tv *tokenVerifier) VerifyTokenClaims(t string) (*TokenClaims, error) {
+	if tv.cache == nil {
+		_, claims, err := tv.verifyTokenClaims(t)
+		return claims, err
+	}
+
+	key := makeTokenCacheKey(t)
+	if entry, ok := tv.cache.get(key, tv.clock()); ok {
+		if err := tv.checkCachedTokenClaims(entry, t); err != nil {
+			tv.cache.remove(key)
+			return nil, err
+		}
+		return copyTokenClaims(entry.claims), nil
+	}
+
+	dt, claims, err := tv.verifyTokenClaims(t)
+	if err != nil {
+		return nil, err
+	}
+	keyID, _ := dt.Header[keyIDHeader].(string)
+	tv.cache.add(&tokenCacheEntry{key: key, keyID: keyID, claims: copyTokenClaims(claims)})
+	return claims, nil
+}
+
+func (tv *tokenVerifier) verifyTokenClaims(t string) (*jwt.Token, *TokenClaims, error) {
+	dt, sub, role, err := tv.preVerify(t)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	if role != TokenAccessUserRole && role != TokenAccessSystemRole && role != TokenRefreshUserRole {
-		return 0, "", xerror.New(ErrorInvalidToken, t)
+		return nil, nil, xerror.New(ErrorInvalidToken, t)
 	}
 	if role == TokenAccessSystemRole && sub != defaultSystemUserID {
-		return 0, "", xerror.New(ErrorInvalidToken, t)
+		return nil, nil, xerror.New(ErrorInvalidToken, t)
 	}
 
 	if err := tv.postVerify(dt, t); err != nil {
-		return 0, "", err
+		return nil, nil, err
 	}
 
-	return sub, role, nil
+	claims, err := tv.getClaims(dt, sub, role, t)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dt, claims, nil
+}
+
+// checkCachedTokenClaims repeats the checks whose outcome can change while a verified token is cached.
+func (tv *tokenVerifier) checkCachedTokenClaims(entry *tokenCacheEntry, t string) error {
+	if _, err := tv.keyProvider.getKey(entry.keyID, tv.clock()); err != nil {
+		return xerror.Wrap(err, ErrorInvalidToken, t)
+	}
+	if tv.revocationStore != nil {
+		return tv.checkClaimsRevocation(entry.claims, t)
+	}
+	return nil
 }
 
 func (tv *tokenVerifier) VerifySinglePurposeToken(t string, descriptor SinglePurposeTokenDescriptor) (int64, map[string]interface{}, error) {
-	dt, sub, role, err := tv.preVerify(t)
+	claims, customClaims, err := tv.VerifySinglePurposeTokenClaims(t, descriptor)
 	if err != nil {
 		return 0, nil, err
 	}
+	return claims.Subject, customClaims, nil
+}
+
+func (tv *tokenVerifier) VerifySinglePurposeTokenClaims(t string, descriptor SinglePurposeTokenDescriptor) (*TokenClaims, map[string]interface{}, error) {
+	if descriptor.IsEncrypted() {
+		decrypted, err := DecryptToken(tv.encryptionKeys, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		t = decrypted
+	}
+
+	dt, sub, role, err := tv.preVerify(t)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	if err := tv.postVerify(dt, t); err != nil {
-		return 0, nil, err
+		return nil, nil, err
 	}
 
 	if role != descriptor.GetRole() {
-		return 0, nil, xerror.New(ErrorInvalidToken, t)
+		return nil, nil, xerror.New(ErrorInvalidToken, t)
 	}
 
 	if descriptor.IsSubMeaningful() && sub <= 0 {
-		return 0, nil, xerror.New(ErrorInvalidToken, t)
+		return nil, nil, xerror.New(ErrorInvalidToken, t)
 	}
 	if !descriptor.IsSubMeaningful() && sub != 0 {
-		return 0, nil, xerror.New(ErrorInvalidToken, t)
+		return nil, nil, xerror.New(ErrorInvalidToken, t)
 	}
 
 	customClaims := make(map[string]interface{}, len(descriptor.GetAllowedCustomClaims()))
-	for cN := range descriptor.GetAllowedCustomClaims() {
+	for cN, cT := range descriptor.GetAllowedCustomClaims() {
 		if cV, ok := dt.Claims[cN]; ok {
-			customClaims[cN] = cV
+			customClaims[cN] = coerceCustomClaim(cV, cT)
 		}
 	}
-	if err := validateCustomClaims(descriptor.GetAllowedCustomClaims(), customClaims); err != nil {
-		return 0, nil, xerror.Wrap(err, ErrorInvalidToken, t)
+	if err := validateDescriptorCustomClaims(descriptor, customClaims); err != nil {
+		return nil, nil, xerror.Wrap(err, ErrorInvalidToken, t)
 	}
 
-	if err := tv.postVerify(dt, t); err != nil {
-		return 0, nil, err
+	claims, err := tv.getClaims(dt, sub, role, t)
+	if err != nil {
+		return nil, nil, err
 	}
+	return claims, customClaims, nil
+}
 
-	return sub, customClaims, nil
+func (tv *tokenVerifier) VerifyTypedSinglePurposeToken(t string, descriptor TypedSinglePurposeTokenDescriptor, claims interface{}) (*TokenClaims, error) {
+	tokenClaims, customClaims, err := tv.VerifySinglePurposeTokenClaims(t, descriptor)
+	if err != nil {
+		return nil, err
+	}
+	if err := descriptor.DecodeClaims(customClaims, claims); err != nil {
+		return nil, err
+	}
+	return tokenClaims, nil
 }
 
 func (tv *tokenVerifier) preVerify(t string) (*jwt.Token, int64, string, error) {
 	dt, err := tv.jwtParser.Parse(t, tv.keyCallback)
-	if err != nil {
+	if err != nil && !isOnlyTimeValidationError(err) { // Times are checked in postVerify, with the configured clock and leeway.
 		return nil, 0, "", xerror.Wrap(err, ErrorInvalidToken, t)
 	}
 
-	v, err := safeGetStringClaim(dt, tokenVersionHeader)
-	if err != nil {
-		return nil, 0, "", err
-	}
-	if v != currentTokenVersion {
-		return nil, 0, "", xerror.New(ErrorInvalidToken, t)
-	}
-
-	sub, err := safeGetStringClaimAsInt64(dt, subjectHeader)
+	sub, err := tv.getSubject(dt, t)
 	if err != nil {
 		return nil, 0, "", err
 	}
@@ -341,11 +761,11 @@ func (tv *tokenVerifier) postVerify(dt *jwt.Token, t string) error {
 		return xerror.New(ErrorInvalidToken, t)
 	}
 
-	aud, err := safeGetStringClaim(dt, audienceHeader)
+	aud, err := safeGetAudienceClaim(dt)
 	if err != nil {
 		return err
 	}
-	if aud != tv.audience {
+	if !tv.isAudienceAccepted(dt, aud) {
 		return xerror.New(ErrorInvalidToken, t)
 	}
 
@@ -353,36 +773,208 @@ func (tv *tokenVerifier) postVerify(dt *jwt.Token, t string) error {
 	if err != nil {
 		return err
 	}
-	if exp < time.Now().Unix() {
-		return xerror.New(ErrorExpiredToken, t)
+	now := tv.clock()
+	if expiresAt := time.Unix(exp, 0); now.After(expiresAt.Add(tv.leeway)) {
+		return xerror.Wrap(xerror.New(errorExpiredBy, now.Sub(expiresAt)), ErrorExpiredToken, t)
+	}
+
+	if _, ok := dt.Claims[notBeforeHeader]; ok {
+		nbf, err := safeGetJSONNumberClaimAsInt64(dt, notBeforeHeader)
+		if err != nil {
+			return err
+		}
+		if now.Before(time.Unix(nbf, 0).Add(-tv.leeway)) {
+			return xerror.Wrap(xerror.New(errorNotValidYet), ErrorInvalidToken, t)
+		}
+	}
+
+	if tv.revocationStore != nil {
+		return tv.checkRevocation(dt, t)
 	}
 
 	return nil
 }
 
+func (tv *tokenVerifier) isAudienceAccepted(dt *jwt.Token, aud []string) bool {
+	if tv.serviceAudience != "" && StringSliceContains(aud, tv.serviceAudience) {
+		return true
+	}
+	if _, isServiceToken := dt.Claims[clientHeader]; isServiceToken {
+		return false // Service tokens are only valid for their target service.
+	}
+	if tv.requireServiceAudience && dt.Claims[roleHeader] == TokenAccessSystemRole {
+		return false
+	}
+	return StringSliceContains(aud, tv.audience)
+}
+
+func (tv *tokenVerifier) getFormat(dt *jwt.Token, t string) (TokenFormat, error) {
+	v, err := safeGetStringClaim(dt, tokenVersionHeader)
+	if err != nil {
+		return nil, err
+	}
+	format, ok := tv.formats[v]
+	if !ok {
+		return nil, xerror.New(ErrorInvalidToken, t)
+	}
+	return format, nil
+}
+
+func (tv *tokenVerifier) getSubject(dt *jwt.Token, t string) (int64, error) {
+	format, err := tv.getFormat(dt, t)
+	if err != nil {
+		return 0, err
+	}
+	return format.DecodeSubject(dt.Claims[subjectHeader])
+}
+
+func (tv *tokenVerifier) getActor(dt *jwt.Token, t string) (*TokenActor, error) {
+	act, ok := dt.Claims[actorHeader]
+	if !ok {
+		return nil, nil
+	}
+	actMap, ok := act.(map[string]interface{})
+	if !ok {
+		return nil, xerror.New(ErrorInvalidToken, t)
+	}
+	format, err := tv.getFormat(dt, t)
+	if err != nil {
+		return nil, err
+	}
+	actorSub, err := format.DecodeSubject(actMap[subjectHeader])
+	if err != nil {
+		return nil, err
+	}
+	return &TokenActor{Subject: actorSub}, nil
+}
+
+func (tv *tokenVerifier) checkRevocation(dt *jwt.Token, t string) error {
+	sub, err := tv.getSubject(dt, t)
+	if err != nil {
+		return err
+	}
+	jti, _ := dt.Claims[tokenIDHeader].(string)
+	fam, _ := dt.Claims[familyHeader].(string)
+	iat, _ := safeGetJSONNumberClaimAsInt64(dt, issuedAtHeader) // Tokens without iat are revoked with any subject revocation.
+
+	return tv.checkClaimsRevocation(&TokenClaims{ID: jti, Family: fam, Subject: sub, IssuedAt: time.Unix(iat, 0)}, t)
+}
+
+func (tv *tokenVerifier) checkClaimsRevocation(claims *TokenClaims, t string) error {
+	isRevoked, err := tv.revocationStore.IsRevoked(claims)
+	if err != nil {
+		return xerror.Wrap(err, ErrorInvalidToken, t)
+	}
+	if isRevoked {
+		return xerror.New(ErrorRevokedToken, t)
+	}
+	return nil
+}
+
+func (tv *tokenVerifier) getClaims(dt *jwt.Token, sub int64, role, t string) (*TokenClaims, error) {
+	exp, err := safeGetJSONNumberClaimAsInt64(dt, expirationHeader)
+	if err != nil {
+		return nil, err
+	}
+	iat, err := safeGetJSONNumberClaimAsInt64(dt, issuedAtHeader)
+	if err != nil {
+		return nil, err
+	}
+	jti, _ := dt.Claims[tokenIDHeader].(string)
+	fam, _ := dt.Claims[familyHeader].(string)
+	client, _ := dt.Claims[clientHeader].(string)
+	aud, err := safeGetAudienceClaim(dt)
+	if err != nil {
+		return nil, err
+	}
+
+	var scopes []string
+	if scope, ok := dt.Claims[scopeHeader]; ok {
+		scopeStr, ok := scope.(string)
+		if !ok {
+			return nil, xerror.New(ErrorInvalidToken, t)
+		}
+		scopes = strings.Fields(scopeStr)
+	}
+
+	actor, err := tv.getActor(dt, t)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenClaims{
+		ID:        jti,
+		Family:    fam,
+		Scopes:    scopes,
+		Actor:     actor,
+		Client:    client,
+		Audience:  aud,
+		Subject:   sub,
+		Role:      role,
+		IssuedAt:  time.Unix(iat, 0),
+		ExpiresAt: time.Unix(exp, 0),
+	}, nil
+}
+
 func (tv *tokenVerifier) keyCallback(token *jwt.Token) (interface{}, error) {
-	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok || token.Header[keyIDHeader].(string) != tv.keyID {
+	keyID, ok := token.Header[keyIDHeader].(string)
+	if !ok {
 		return nil, xerror.New(ErrorInvalidTokenHeader, token)
 	}
-	return tv.publicKey, nil
+	key, err := tv.keyProvider.getKey(keyID, tv.clock())
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, xerror.New(ErrorInvalidTokenHeader, token)
+	}
+	return key.key, nil
+}
+
+func makeTokenFormatsMap(formats []TokenFormat) map[string]TokenFormat {
+	formatsMap := make(map[string]TokenFormat, len(formats))
+	for _, format := range formats {
+		formatsMap[format.GetVersion()] = format
+	}
+	return formatsMap
+}
+
+func isOnlyTimeValidationError(err error) bool {
+	vErr, ok := err.(*jwt.ValidationError)
+	return ok && vErr.Errors != 0 && vErr.Errors&^(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) == 0
 }
 
 func validateParams(keyID string, key []byte, issuer, audience string) error {
-	if !regexp.MustCompile("^k[0-9]$").MatchString(keyID) {
+	if !keyIDRegexp.MatchString(keyID) {
 		return xerror.New(ErrorInvalidKeyID, keyID)
 	}
 	if len(key) == 0 {
 		return xerror.New(ErrorInvalidKeyMaterial)
 	}
+	return validateIssuerAndAudience(issuer, audience)
+}
+
+func validateIssuerAndAudience(issuer, audience string) error {
 	if len(issuer) == 0 || !strings.HasPrefix(issuer, "https://") {
 		return xerror.New(ErrorInvalidIssuer, issuer)
 	}
+	return validateAudience(audience)
+}
+
+func validateAudience(audience string) error {
 	if len(audience) == 0 || !strings.HasPrefix(audience, "connect-") {
 		return xerror.New(ErrorInvalidAudience, audience)
 	}
 	return nil
 }
 
+func validateServiceName(name string) error {
+	if !serviceNameRegexp.MatchString(name) {
+		return xerror.New(ErrorInvalidServiceName, name)
+	}
+	return nil
+}
+
 func safeGetStringClaim(t *jwt.Token, claimName string) (string, error) {
 	if claimValue, ok := t.Claims[claimName]; ok {
 		if claimStr, ok := claimValue.(string); ok {
@@ -393,16 +985,24 @@ func safeGetStringClaim(t *jwt.Token, claimName string) (string, error) {
 	return "", xerror.New(ErrorInvalidToken, t)
 }
 
-func safeGetStringClaimAsInt64(t *jwt.Token, claimName string) (int64, error) {
-	claimStr, err := safeGetStringClaim(t, claimName)
-	if err != nil {
-		return 0, err
-	}
-	claimInt64, err := strconv.ParseInt(claimStr, 10, 64)
-	if err != nil {
-		return 0, xerror.Wrap(err, ErrorInvalidToken, t)
+// safeGetAudienceClaim returns the audience claim, which can either be a string or an array of strings (RFC 7519).
+func safeGetAudienceClaim(t *jwt.Token) ([]string, error) {
+	switch aud := t.Claims[audienceHeader].(type) {
+	case string:
+		return []string{aud}, nil
+	case []interface{}:
+		auds := make([]string, 0, len(aud))
+		for _, a := range aud {
+			audStr, ok := a.(string)
+			if !ok {
+				return nil, xerror.New(ErrorInvalidToken, t)
+			}
+			auds = append(auds, audStr)
+		}
+		return auds, nil
+	default:
+		return nil, xerror.New(ErrorInvalidToken, t)
 	}
-	return claimInt64, nil
 }
 
 func safeGetJSONNumberClaimAsInt64(t *jwt.Token, claimName string) (int64, error) {
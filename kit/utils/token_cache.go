@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTokenCacheSize is a reasonable number of verified tokens to cache.
+	DefaultTokenCacheSize = 10000
+)
+
+// TokenCacheReporter describes the capability of reporting lookups in a cache of verified tokens, e.g. as metrics.
+type TokenCacheReporter interface {
+	ReportTokenCacheLookup(isHit bool)
+}
+
+// TokenVerifierCache makes the TokenVerifier cache up to size verified tokens, so that tokens presented repeatedly skip
+// signature verification. Entries never outlive the expiration of their token, and the key and revocation checks are
+// repeated on every hit. Only VerifyToken and VerifyTokenClaims use the cache. The reporter can be nil.
+func TokenVerifierCache(size int, reporter TokenCacheReporter) TokenVerifierOption {
+	return func(tv *tokenVerifier) {
+		tv.cache = newTokenCache(size, reporter)
+	}
+}
+
+type tokenCacheKey [sha256.Size]byte
+
+type tokenCacheEntry struct {
+	key    tokenCacheKey
+	keyID  string
+	claims *TokenClaims
+}
+
+// tokenCache is a thread-safe LRU cache of verified token claims, keyed by a hash of the token.
+type tokenCache struct {
+	mutex    *sync.Mutex
+	size     int
+	reporter TokenCacheReporter
+	entries  map[tokenCacheKey]*list.Element
+	lru      *list.List
+}
+
+func newTokenCache(size int, reporter TokenCacheReporter) *tokenCache {
+	return &tokenCache{
+		mutex:    &sync.Mutex{},
+		size:     size,
+		reporter: reporter,
+		entries:  make(map[tokenCacheKey]*list.Element, size),
+		lru:      list.New(),
+	}
+}
+
+func makeTokenCacheKey(t string) tokenCacheKey {
+	return sha256.Sum256([]byte(t))
+}
+
+// copyTokenClaims makes a deep copy of the claims, so that callers cannot alter cached claims.
+func copyTokenClaims(claims *TokenClaims) *TokenClaims {
+	copied := *claims
+	if claims.Scopes != nil {
+		copied.Scopes = append([]string(nil), claims.Scopes...)
+	}
+	if claims.Audience != nil {
+		copied.Audience = append([]string(nil), claims.Audience...)
+	}
+	if claims.Actor != nil {
+		actor := *claims.Actor
+		copied.Actor = &actor
+	}
+	return &copied
+}
+
+// get returns the entry for the given key, if present and not expired at the given time.
+func (c *tokenCache) get(key tokenCacheKey, now time.Time) (*tokenCacheEntry, bool) {
+	entry, ok := c.safeGet(key, now)
+	if c.reporter != nil {
+		c.reporter.ReportTokenCacheLookup(ok)
+	}
+	return entry, ok
+}
+
+func (c *tokenCache) safeGet(key tokenCacheKey, now time.Time) (*tokenCacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*tokenCacheEntry)
+	if !now.Before(entry.claims.ExpiresAt) {
+		c.removeElement(element)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry, true
+}
+
+// add adds an entry, evicting the least recently used one if the cache is full.
+func (c *tokenCache) add(entry *tokenCacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[entry.key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	if c.size <= 0 {
+		return
+	}
+	for c.lru.Len() >= c.size {
+		c.removeElement(c.lru.Back())
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+}
+
+func (c *tokenCache) remove(key tokenCacheKey) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+func (c *tokenCache) removeElement(element *list.Element) {
+	c.lru.Remove(element)
+	delete(c.entries, element.Value.(*tokenCacheEntry).key)
+}
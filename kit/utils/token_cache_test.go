@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type testTokenCacheReporter struct {
+	hits   int
+	misses int
+}
+
+func (r *testTokenCacheReporter) ReportTokenCacheLookup(isHit bool) {
+	if isHit {
+		r.hits++
+	} else {
+		r.misses++
+	}
+}
+
+func TestTokenCache(t *testing.T) {
+	now := time.Now()
+	reporter := &testTokenCacheReporter{}
+	cache := newTokenCache(2, reporter)
+
+	for _, t := range []string{"a", "b", "c"} {
+		cache.add(&tokenCacheEntry{key: makeTokenCacheKey(t), claims: &TokenClaims{ID: t, ExpiresAt: now.Add(time.Hour)}})
+	}
+	_, ok := cache.get(makeTokenCacheKey("a"), now)
+	assert.False(t, ok)
+	entry, ok := cache.get(makeTokenCacheKey("b"), now)
+	assert.True(t, ok)
+	assert.Equal(t, "b", entry.claims.ID)
+
+	// The least recently used entry is evicted.
+	cache.add(&tokenCacheEntry{key: makeTokenCacheKey("d"), claims: &TokenClaims{ID: "d", ExpiresAt: now.Add(time.Minute)}})
+	_, ok = cache.get(makeTokenCacheKey("c"), now)
+	assert.False(t, ok)
+	_, ok = cache.get(makeTokenCacheKey("b"), now)
+	assert.True(t, ok)
+
+	// Expired entries are dropped.
+	_, ok = cache.get(makeTokenCacheKey("d"), now.Add(time.Minute))
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.lru.Len())
+
+	cache.remove(makeTokenCacheKey("b"))
+	_, ok = cache.get(makeTokenCacheKey("b"), now)
+	assert.False(t, ok)
+	assert.Equal(t, 0, cache.lru.Len())
+	assert.Equal(t, 0, len(cache.entries))
+
+	assert.Equal(t, 2, reporter.hits)
+	assert.Equal(t, 4, reporter.misses)
+}
+
+func TestVerifyWithCache(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	reporter := &testTokenCacheReporter{}
+	revocationStore := NewMemoryTokenRevocationStore(DefaultRefreshTokenLifetime)
+
+	ti, err := NewTokenIssuer(keyID, privateKey, issuer, audience, DefaultRefreshTokenLifetime, time.Hour, TokenIssuerClock(clock))
+	assert.Nil(t, err)
+	tv, err := NewTokenVerifier(keyID, publicKey, issuer, audience, TokenVerifierClock(clock), TokenVerifierLeeway(time.Minute),
+		TokenVerifierRevocationStore(revocationStore), TokenVerifierCache(DefaultTokenCacheSize, reporter))
+	assert.Nil(t, err)
+
+	uat, err := ti.IssueScopedAccessUserToken(1, "messages:read")
+	assert.Nil(t, err)
+	claims, err := tv.VerifyTokenClaims(uat)
+	assert.Nil(t, err)
+	cachedClaims, err := tv.VerifyTokenClaims(uat)
+	assert.Nil(t, err)
+	assert.Equal(t, claims, cachedClaims)
+	sub, role, err := tv.VerifyToken(uat)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), sub)
+	assert.Equal(t, TokenAccessUserRole, role)
+	assert.Equal(t, 2, reporter.hits)
+	assert.Equal(t, 1, reporter.misses)
+
+	// Callers cannot alter cached claims.
+	cachedClaims.Subject = 2
+	cachedClaims.Scopes[0] = "admin:users"
+	cachedClaims.Audience[0] = "other"
+	claims, err = tv.VerifyTokenClaims(uat)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), claims.Subject)
+	assert.Equal(t, []string{"messages:read"}, claims.Scopes)
+	assert.Equal(t, []string{audience}, claims.Audience)
+
+	// Invalid tokens are not cached.
+	_, err = tv.VerifyTokenClaims(uat + "x")
+	assert.NotNil(t, err)
+	_, err = tv.VerifyTokenClaims(uat + "x")
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, reporter.hits)
+	assert.Equal(t, 3, reporter.misses)
+
+	// Entries do not outlive the token, and the leeway still applies on verification.
+	now = now.Add(time.Hour + 30*time.Second)
+	_, err = tv.VerifyTokenClaims(uat)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, reporter.hits)
+	assert.Equal(t, 4, reporter.misses)
+	now = now.Add(time.Minute)
+	_, err = tv.VerifyTokenClaims(uat)
+	assert.NotNil(t, err)
+
+	// Revocations apply to cached tokens.
+	now = time.Now()
+	uat, err = ti.IssueAccessUserToken(2)
+	assert.Nil(t, err)
+	_, err = tv.VerifyTokenClaims(uat)
+	assert.Nil(t, err)
+	assert.Nil(t, revocationStore.RevokeSubject(2, now.Add(time.Second)))
+	_, err = tv.VerifyTokenClaims(uat)
+	assert.Equal(t, "revoked token", err.Error())
+	_, err = tv.VerifyTokenClaims(uat)
+	assert.Equal(t, "revoked token", err.Error())
+
+	// Callers cannot alter the actor of cached delegated claims.
+	dat, err := ti.IssueDelegatedAccessUserToken(1, 2)
+	assert.Nil(t, err)
+	claims, err = tv.VerifyTokenClaims(dat)
+	assert.Nil(t, err)
+	claims.Actor.Subject = 3
+	claims, err = tv.VerifyTokenClaims(dat)
+	assert.Nil(t, err)
+	assert.Equal(t, &TokenActor{Subject: 2}, claims.Actor)
+}
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	// ErrorInvalidClaimsType is returned when a claims value does not match the type of a typed descriptor.
+	ErrorInvalidClaimsType = "invalid claims type: %v"
+)
+
+var reservedClaims = map[string]bool{
+	tokenVersionHeader: true,
+	subjectHeader:      true,
+	roleHeader:         true,
+	issuerHeader:       true,
+	audienceHeader:     true,
+	expirationHeader:   true,
+	notBeforeHeader:    true,
+	issuedAtHeader:     true,
+	tokenIDHeader:      true,
+	familyHeader:       true,
+	scopeHeader:        true,
+	actorHeader:        true,
+	clientHeader:       true,
+}
+
+// TypedSinglePurposeTokenDescriptor is a SinglePurposeTokenDescriptor whose custom claims are described by a struct.
+type TypedSinglePurposeTokenDescriptor interface {
+	SinglePurposeTokenDescriptor
+	GetClaimsType() reflect.Type
+	EncodeClaims(claims interface{}) (map[string]interface{}, error)
+	DecodeClaims(customClaims map[string]interface{}, claims interface{}) error
+}
+
+type typedSinglePurposeTokenDescriptor struct {
+	singlePurposeTokenDescriptor
+	claimsType     reflect.Type
+	requiredClaims map[string]bool
+}
+
+// NewTypedSinglePurposeTokenDescriptor initializes a new TypedSinglePurposeTokenDescriptor from a prototype struct value.
+// Custom claims are named after the JSON tags of the exported fields: fields tagged "omitempty" are optional, all the
+// others are required. Claims are encoded and decoded with encoding/json, so numeric fields keep their exact type.
+func NewTypedSinglePurposeTokenDescriptor(role string, isSubMeaningful bool, lifetime time.Duration, claimsPrototype interface{}, options ...SinglePurposeTokenDescriptorOption) (TypedSinglePurposeTokenDescriptor, error) {
+	claimsType := reflect.TypeOf(claimsPrototype)
+	if claimsType == nil || claimsType.Kind() != reflect.Struct {
+		return nil, xerror.New(ErrorInvalidClaimsType, claimsType)
+	}
+
+	allowedCustomClaims := make(map[string]reflect.Type, claimsType.NumField())
+	requiredClaims := make(map[string]bool, claimsType.NumField())
+
+	for i := 0; i < claimsType.NumField(); i++ {
+		field := claimsType.Field(i)
+		if field.PkgPath != "" {
+			continue // Unexported.
+		}
+		if field.Anonymous {
+			return nil, xerror.New(ErrorInvalidClaimsType, claimsType)
+		}
+
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = field.Name
+		}
+		if reservedClaims[name] || allowedCustomClaims[name] != nil {
+			return nil, xerror.New(ErrorInvalidCustomClaim, name)
+		}
+
+		allowedCustomClaims[name] = field.Type
+		requiredClaims[name] = !StringSliceContains(tag[1:], "omitempty")
+	}
+
+	s := &typedSinglePurposeTokenDescriptor{
+		singlePurposeTokenDescriptor: singlePurposeTokenDescriptor{
+			role:                role,
+			isSubMeaningful:     isSubMeaningful,
+			lifetime:            lifetime,
+			allowedCustomClaims: allowedCustomClaims,
+		},
+		claimsType:     claimsType,
+		requiredClaims: requiredClaims,
+	}
+	for _, option := range options {
+		option(&s.singlePurposeTokenDescriptor)
+	}
+	return s, nil
+}
+
+// MustNewTypedSinglePurposeTokenDescriptor is like NewTypedSinglePurposeTokenDescriptor, but panics on error.
+func MustNewTypedSinglePurposeTokenDescriptor(role string, isSubMeaningful bool, lifetime time.Duration, claimsPrototype interface{}, options ...SinglePurposeTokenDescriptorOption) TypedSinglePurposeTokenDescriptor {
+	descriptor, err := NewTypedSinglePurposeTokenDescriptor(role, isSubMeaningful, lifetime, claimsPrototype, options...)
+	if err != nil {
+		panic(err)
+	}
+	return descriptor
+}
+
+// GetClaimsType implements the TypedSinglePurposeTokenDescriptor interface.
+func (s *typedSinglePurposeTokenDescriptor) GetClaimsType() reflect.Type {
+	return s.claimsType
+}
+
+// EncodeClaims implements the TypedSinglePurposeTokenDescriptor interface. It accepts a struct value or pointer.
+func (s *typedSinglePurposeTokenDescriptor) EncodeClaims(claims interface{}) (map[string]interface{}, error) {
+	claimsValue := reflect.ValueOf(claims)
+	if claimsValue.Kind() == reflect.Ptr && !claimsValue.IsNil() {
+		claimsValue = claimsValue.Elem()
+	}
+	if !claimsValue.IsValid() || claimsValue.Type() != s.claimsType {
+		return nil, xerror.New(ErrorInvalidClaimsType, reflect.TypeOf(claims))
+	}
+
+	buf, err := json.Marshal(claimsValue.Interface())
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidClaimsType, s.claimsType)
+	}
+	customClaims := map[string]interface{}{}
+	if err := unmarshalJSONWithNumbers(buf, &customClaims); err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidClaimsType, s.claimsType)
+	}
+	return customClaims, nil
+}
+
+// DecodeClaims implements the TypedSinglePurposeTokenDescriptor interface. It requires a struct pointer.
+func (s *typedSinglePurposeTokenDescriptor) DecodeClaims(customClaims map[string]interface{}, claims interface{}) error {
+	if claimsType := reflect.TypeOf(claims); claimsType == nil || claimsType.Kind() != reflect.Ptr || claimsType.Elem() != s.claimsType {
+		return xerror.New(ErrorInvalidClaimsType, claimsType)
+	}
+	for cN := range customClaims {
+		if _, ok := s.requiredClaims[cN]; !ok {
+			return xerror.New(ErrorInvalidCustomClaim, cN)
+		}
+	}
+	missingClaims := map[string]bool{}
+	for rcN, isRequired := range s.requiredClaims {
+		if _, ok := customClaims[rcN]; isRequired && !ok {
+			missingClaims[rcN] = true
+		}
+	}
+	if len(missingClaims) != 0 {
+		return xerror.New(ErrorMissingCustomClaims, missingClaims)
+	}
+
+	buf, err := json.Marshal(customClaims)
+	if err != nil {
+		return xerror.Wrap(err, ErrorInvalidCustomClaim, customClaims)
+	}
+	if err := unmarshalJSONWithNumbers(buf, claims); err != nil {
+		return xerror.Wrap(err, ErrorInvalidCustomClaim, customClaims)
+	}
+	return nil
+}
+
+// coerceCustomClaim converts JSON numbers into the numeric type expected by an untyped descriptor, if possible.
+func coerceCustomClaim(claimValue interface{}, claimType reflect.Type) interface{} {
+	claimNumber, ok := claimValue.(json.Number)
+	if !ok || claimType == nil {
+		return claimValue
+	}
+
+	value := reflect.New(claimType).Elem()
+	switch claimType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := claimNumber.Int64()
+		if err != nil || value.OverflowInt(i) {
+			return claimValue
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := claimNumber.Int64()
+		if err != nil || i < 0 || value.OverflowUint(uint64(i)) {
+			return claimValue
+		}
+		value.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		f, err := claimNumber.Float64()
+		if err != nil || value.OverflowFloat(f) {
+			return claimValue
+		}
+		value.SetFloat(f)
+	default:
+		return claimValue
+	}
+	return value.Interface()
+}
+
+// validateDescriptorCustomClaims validates custom claims against any descriptor. Custom claims cannot be named after the
+// standard claims, which they would otherwise overwrite when issuing.
+func validateDescriptorCustomClaims(descriptor SinglePurposeTokenDescriptor, customClaims map[string]interface{}) error {
+	for name := range descriptor.GetAllowedCustomClaims() {
+		if reservedClaims[name] {
+			return xerror.New(ErrorInvalidCustomClaim, name)
+		}
+	}
+	if typedDescriptor, ok := descriptor.(TypedSinglePurposeTokenDescriptor); ok {
+		return typedDescriptor.DecodeClaims(customClaims, reflect.New(typedDescriptor.GetClaimsType()).Interface())
+	}
+	return validateCustomClaims(descriptor.GetAllowedCustomClaims(), customClaims)
+}
+
+func unmarshalJSONWithNumbers(buf []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// StringSliceContains returns true if the given slice contains the given value.
+func StringSliceContains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
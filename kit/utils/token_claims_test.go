@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+)
+
+type testResetClaims struct {
+	UserID   int64  `json:"userId"`
+	Email    string `json:"email"`
+	Attempts uint8  `json:"attempts,omitempty"`
+	Ignored  string `json:"-"`
+	internal string
+}
+
+func TestTypedSinglePurposeToken(t *testing.T) {
+	descriptor := MustNewTypedSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, testResetClaims{})
+	assert.Equal(t, map[string]reflect.Type{
+		"userId":   reflect.TypeOf(int64(0)),
+		"email":    reflect.TypeOf(""),
+		"attempts": reflect.TypeOf(uint8(0)),
+	}, descriptor.GetAllowedCustomClaims())
+
+	ti, err := NewTokenIssuer(keyID, privateKey, issuer, audience, DefaultRefreshTokenLifetime, DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+
+	// Round trip, including integers that do not fit in a float64.
+	spt, err := ti.IssueTypedSinglePurposeToken(descriptor, 1, testResetClaims{UserID: 1<<62 + 1, Email: "a@b.c", Attempts: 3, Ignored: "x"})
+	assert.Nil(t, err)
+	claims := &testResetClaims{}
+	tokenClaims, err := tv.VerifyTypedSinglePurposeToken(spt, descriptor, claims)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), tokenClaims.Subject)
+	assert.Equal(t, &testResetClaims{UserID: 1<<62 + 1, Email: "a@b.c", Attempts: 3}, claims)
+
+	// Optional claims can be omitted.
+	spt, err = ti.IssueTypedSinglePurposeToken(descriptor, 1, &testResetClaims{UserID: 2})
+	assert.Nil(t, err)
+	claims = &testResetClaims{}
+	_, err = tv.VerifyTypedSinglePurposeToken(spt, descriptor, claims)
+	assert.Nil(t, err)
+	assert.Equal(t, &testResetClaims{UserID: 2}, claims)
+
+	// The map API validates against the struct too.
+	spt, err = ti.IssueSinglePurposeToken(descriptor, 1, map[string]interface{}{"userId": 3, "email": "a@b.c"})
+	assert.Nil(t, err)
+	_, customClaims, err := tv.VerifySinglePurposeToken(spt, descriptor)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(customClaims))
+	_, err = ti.IssueSinglePurposeToken(descriptor, 1, map[string]interface{}{"userId": 3})
+	assert.Equal(t, "missing custom claims: map[email:true]", err.Error())
+	_, err = ti.IssueSinglePurposeToken(descriptor, 1, map[string]interface{}{"userId": 3, "email": "a@b.c", "extra": 1})
+	assert.Equal(t, "invalid custom claim: extra", err.Error())
+	_, err = ti.IssueSinglePurposeToken(descriptor, 1, map[string]interface{}{"userId": "3", "email": "a@b.c"})
+	assert.NotNil(t, err)
+
+	// Tokens missing required claims are rejected.
+	untypedDescriptor := NewSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, map[string]reflect.Type{"userId": reflect.TypeOf(0)})
+	spt, err = ti.IssueSinglePurposeToken(untypedDescriptor, 1, map[string]interface{}{"userId": 4})
+	assert.Nil(t, err)
+	_, err = tv.VerifyTypedSinglePurposeToken(spt, descriptor, &testResetClaims{})
+	assert.Equal(t, "invalid token: missing custom claims: map[email:true]", err.Error())
+
+	// Wrong claims types.
+	_, err = ti.IssueTypedSinglePurposeToken(descriptor, 1, struct{}{})
+	assert.Equal(t, "invalid claims type: struct {}", err.Error())
+	_, err = tv.VerifyTypedSinglePurposeToken(spt, descriptor, testResetClaims{})
+	assert.NotNil(t, err)
+}
+
+func TestUntypedSinglePurposeTokenNumericClaims(t *testing.T) {
+	descriptor := NewSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, map[string]reflect.Type{
+		"int64":   reflect.TypeOf(int64(0)),
+		"uint8":   reflect.TypeOf(uint8(0)),
+		"float64": reflect.TypeOf(float64(0)),
+	})
+
+	ti, err := NewTokenIssuer(keyID, privateKey, issuer, audience, DefaultRefreshTokenLifetime, DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	tv, err := NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+
+	spt, err := ti.IssueSinglePurposeToken(descriptor, 1, map[string]interface{}{"int64": int64(1 << 62), "uint8": uint8(200), "float64": 1.5})
+	assert.Nil(t, err)
+	_, customClaims, err := tv.VerifySinglePurposeToken(spt, descriptor)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"int64": int64(1 << 62), "uint8": uint8(200), "float64": 1.5}, customClaims)
+
+	// Custom claims cannot overwrite the standard claims.
+	reservedDescriptor := NewSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, map[string]reflect.Type{"role": reflect.TypeOf("")})
+	_, err = ti.IssueSinglePurposeToken(reservedDescriptor, 1, map[string]interface{}{"role": TokenAccessSystemRole})
+	assert.Equal(t, "invalid custom claim: role", err.Error())
+	_, _, err = tv.VerifySinglePurposeToken(spt, reservedDescriptor)
+	assert.Equal(t, "invalid token: invalid custom claim: role", err.Error())
+}
+
+func TestNewTypedSinglePurposeTokenDescriptor(t *testing.T) {
+	_, err := NewTypedSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, &testResetClaims{})
+	assert.Equal(t, "invalid claims type: *utils.testResetClaims", err.Error())
+
+	_, err = NewTypedSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, struct {
+		Sub string `json:"sub"`
+	}{})
+	assert.Equal(t, "invalid custom claim: sub", err.Error())
+
+	duplicateTagType := reflect.StructOf([]reflect.StructField{
+		{Name: "A", Type: reflect.TypeOf(""), Tag: `json:"a"`},
+		{Name: "B", Type: reflect.TypeOf(""), Tag: `json:"a"`},
+	})
+	_, err = NewTypedSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, reflect.New(duplicateTagType).Elem().Interface())
+	assert.Equal(t, "invalid custom claim: a", err.Error())
+
+	assert.Panics(t, func() {
+		MustNewTypedSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, nil)
+	})
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"strings"
+)
+
+const (
+	// ErrorMissingEncryptionKey is returned when issuing an encrypted token without an encryption key.
+	ErrorMissingEncryptionKey = "missing encryption key"
+	// ErrorInvalidEncryptionKey is returned when an encryption key is not a 256 bit AES key.
+	ErrorInvalidEncryptionKey = "invalid encryption key: %v"
+)
+
+const (
+	// EncryptionKeySize is the size of the keys used for encrypting tokens (A256GCM).
+	EncryptionKeySize = 32
+
+	encryptionAlgorithm = "dir"
+	encryptionMethod    = "A256GCM"
+	contentTypeJWT      = "JWT"
+)
+
+// jweHeader is the protected header of an encrypted token (RFC 7516), which carries a signed token (RFC 7519, 5.2).
+type jweHeader struct {
+	Algorithm   string `json:"alg"`
+	Encryption  string `json:"enc"`
+	KeyID       string `json:"kid"`
+	ContentType string `json:"cty"`
+}
+
+// encryptToken wraps a signed token into a JWE, using direct encryption with a shared AES-256-GCM key.
+func encryptToken(keyID string, key []byte, t string) (string, error) {
+	aead, err := newTokenAEAD(keyID, key)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(&jweHeader{
+		Algorithm:   encryptionAlgorithm,
+		Encryption:  encryptionMethod,
+		KeyID:       keyID,
+		ContentType: contentTypeJWT,
+	})
+	if err != nil {
+		return "", xerror.Wrap(err, ErrorUnableToSignToken)
+	}
+	encodedHeader := jwt.EncodeSegment(header)
+
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", xerror.Wrap(err, ErrorUnableToSignToken)
+	}
+	sealed := aead.Seal(nil, iv, []byte(t), []byte(encodedHeader))
+	ciphertext, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+
+	// The encrypted key is empty with direct encryption.
+	return strings.Join([]string{encodedHeader, "", jwt.EncodeSegment(iv), jwt.EncodeSegment(ciphertext), jwt.EncodeSegment(tag)}, "."), nil
+}
+
+// IsEncryptedToken returns true if the token is in the JWE compact serialization, as produced for encrypted descriptors.
+func IsEncryptedToken(t string) bool {
+	return strings.Count(t, ".") == 4
+}
+
+// DecryptToken unwraps the signed token from a JWE produced for an encrypted descriptor, using the AES-256 keys by ID.
+// The signed token still needs to be verified.
+func DecryptToken(keys map[string][]byte, t string) (string, error) {
+	parts := strings.Split(t, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return "", xerror.New(ErrorInvalidToken, t)
+	}
+
+	rawHeader, err := jwt.DecodeSegment(parts[0])
+	if err != nil {
+		return "", xerror.Wrap(err, ErrorInvalidToken, t)
+	}
+	header := &jweHeader{}
+	if err := json.Unmarshal(rawHeader, header); err != nil {
+		return "", xerror.Wrap(err, ErrorInvalidToken, t)
+	}
+	if header.Algorithm != encryptionAlgorithm || header.Encryption != encryptionMethod || header.ContentType != contentTypeJWT {
+		return "", xerror.New(ErrorInvalidTokenHeader, header)
+	}
+	key, ok := keys[header.KeyID]
+	if !ok {
+		return "", xerror.New(ErrorInvalidTokenHeader, header)
+	}
+	aead, err := newTokenAEAD(header.KeyID, key)
+	if err != nil {
+		return "", err
+	}
+
+	var segments [3][]byte
+	for i, part := range parts[2:] {
+		if segments[i], err = jwt.DecodeSegment(part); err != nil {
+			return "", xerror.Wrap(err, ErrorInvalidToken, t)
+		}
+	}
+	iv, ciphertext, tag := segments[0], segments[1], segments[2]
+	if len(iv) != aead.NonceSize() || len(tag) != aead.Overhead() {
+		return "", xerror.New(ErrorInvalidToken, t)
+	}
+
+	plaintext, err := aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", xerror.Wrap(err, ErrorInvalidToken, t)
+	}
+	return string(plaintext), nil
+}
+
+func newTokenAEAD(keyID string, key []byte) (cipher.AEAD, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, xerror.New(ErrorInvalidEncryptionKey, keyID)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidEncryptionKey, keyID)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidEncryptionKey, keyID)
+	}
+	return aead, nil
+}
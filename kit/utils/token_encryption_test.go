@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"bytes"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	encryptionKeyID = "e1"
+	encryptionKey   = bytes.Repeat([]byte{1}, EncryptionKeySize)
+)
+
+func TestEncryptedSinglePurposeToken(t *testing.T) {
+	descriptor := NewSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, map[string]reflect.Type{"email": reflect.TypeOf("")}, SinglePurposeTokenEncrypted())
+	plainDescriptor := NewSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, map[string]reflect.Type{"email": reflect.TypeOf("")})
+
+	ti, err := NewTokenIssuer(keyID, privateKey, issuer, audience, DefaultRefreshTokenLifetime, DefaultAccessTokenLifetime, TokenIssuerEncryptionKey(encryptionKeyID, encryptionKey))
+	assert.Nil(t, err)
+	tv, err := NewTokenVerifier(keyID, publicKey, issuer, audience, TokenVerifierEncryptionKeys(map[string][]byte{encryptionKeyID: encryptionKey}))
+	assert.Nil(t, err)
+
+	spt, err := ti.IssueSinglePurposeToken(descriptor, 1, map[string]interface{}{"email": "a@b.c"})
+	assert.Nil(t, err)
+	parts := strings.Split(spt, ".")
+	assert.Equal(t, 5, len(parts))
+	for _, part := range parts {
+		segment, err := jwt.DecodeSegment(part)
+		assert.Nil(t, err)
+		assert.False(t, bytes.Contains(segment, []byte("a@b.c")))
+	}
+	assert.True(t, IsEncryptedToken(spt))
+	decrypted, err := DecryptToken(map[string][]byte{encryptionKeyID: encryptionKey}, spt)
+	assert.Nil(t, err)
+	assert.False(t, IsEncryptedToken(decrypted))
+	_, _, err = tv.VerifySinglePurposeToken(decrypted, plainDescriptor)
+	assert.Nil(t, err)
+
+	sub, customClaims, err := tv.VerifySinglePurposeToken(spt, descriptor)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), sub)
+	assert.Equal(t, map[string]interface{}{"email": "a@b.c"}, customClaims)
+
+	// Encrypted tokens are only accepted for encrypted descriptors, and vice versa.
+	_, _, err = tv.VerifySinglePurposeToken(spt, plainDescriptor)
+	assert.NotNil(t, err)
+	plainSPT, err := ti.IssueSinglePurposeToken(plainDescriptor, 1, map[string]interface{}{"email": "a@b.c"})
+	assert.Nil(t, err)
+	_, _, err = tv.VerifySinglePurposeToken(plainSPT, descriptor)
+	assert.Equal(t, "invalid token", err.Error())
+
+	// Tampered tokens.
+	parts[3] = jwt.EncodeSegment(append([]byte{0}, []byte(parts[3])...))
+	_, _, err = tv.VerifySinglePurposeToken(strings.Join(parts, "."), descriptor)
+	assert.NotNil(t, err)
+
+	// Missing or wrong keys.
+	noKeyTV, err := NewTokenVerifier(keyID, publicKey, issuer, audience)
+	assert.Nil(t, err)
+	_, _, err = noKeyTV.VerifySinglePurposeToken(spt, descriptor)
+	assert.NotNil(t, err)
+	wrongKeyTV, err := NewTokenVerifier(keyID, publicKey, issuer, audience, TokenVerifierEncryptionKeys(map[string][]byte{encryptionKeyID: bytes.Repeat([]byte{2}, EncryptionKeySize)}))
+	assert.Nil(t, err)
+	_, _, err = wrongKeyTV.VerifySinglePurposeToken(spt, descriptor)
+	assert.NotNil(t, err)
+
+	noKeyTI, err := NewTokenIssuer(keyID, privateKey, issuer, audience, DefaultRefreshTokenLifetime, DefaultAccessTokenLifetime)
+	assert.Nil(t, err)
+	_, err = noKeyTI.IssueSinglePurposeToken(descriptor, 1, map[string]interface{}{"email": "a@b.c"})
+	assert.Equal(t, "missing encryption key", err.Error())
+	shortKeyTI, err := NewTokenIssuer(keyID, privateKey, issuer, audience, DefaultRefreshTokenLifetime, DefaultAccessTokenLifetime, TokenIssuerEncryptionKey(encryptionKeyID, []byte("short")))
+	assert.Nil(t, err)
+	_, err = shortKeyTI.IssueSinglePurposeToken(descriptor, 1, map[string]interface{}{"email": "a@b.c"})
+	assert.Equal(t, "invalid encryption key: e1", err.Error())
+}
+
+func TestEncryptedTypedSinglePurposeToken(t *testing.T) {
+	descriptor := MustNewTypedSinglePurposeTokenDescriptor(purpose, true, DefaultSinglePurposeTokenLifetime, testResetClaims{}, SinglePurposeTokenEncrypted())
+
+	ti, err := NewTokenIssuer(keyID, privateKey, issuer, audience, DefaultRefreshTokenLifetime, DefaultAccessTokenLifetime, TokenIssuerEncryptionKey(encryptionKeyID, encryptionKey))
+	assert.Nil(t, err)
+	tv, err := NewTokenVerifier(keyID, publicKey, issuer, audience, TokenVerifierEncryptionKeys(map[string][]byte{encryptionKeyID: encryptionKey}))
+	assert.Nil(t, err)
+
+	spt, err := ti.IssueTypedSinglePurposeToken(descriptor, 1, testResetClaims{UserID: 2, Email: "a@b.c"})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(strings.Split(spt, ".")))
+	claims := &testResetClaims{}
+	_, err = tv.VerifyTypedSinglePurposeToken(spt, descriptor, claims)
+	assert.Nil(t, err)
+	assert.Equal(t, &testResetClaims{UserID: 2, Email: "a@b.c"}, claims)
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"encoding/json"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"strconv"
+)
+
+// TokenFormat describes a version of the token claims layout.
+type TokenFormat interface {
+	GetVersion() string
+	EncodeSubject(sub int64) interface{}
+	DecodeSubject(claim interface{}) (int64, error)
+}
+
+const (
+	tokenVersionV1 = "v1"
+	tokenVersionV2 = "v2"
+)
+
+var (
+	// TokenFormatV1 encodes the subject as a decimal string.
+	TokenFormatV1 TokenFormat = &tokenFormatV1{}
+	// TokenFormatV2 encodes the subject as a JSON number. It is not a StringOrURI as required by RFC 7519, so it must
+	// only be issued once all the verifiers accept it.
+	TokenFormatV2 TokenFormat = &tokenFormatV2{}
+	// DefaultTokenFormat is the format emitted by a TokenIssuer unless otherwise configured, see TokenIssuerFormat.
+	DefaultTokenFormat = TokenFormatV1
+	// DefaultTokenFormats are the formats accepted by a TokenVerifier unless otherwise configured.
+	DefaultTokenFormats = []TokenFormat{TokenFormatV1, TokenFormatV2}
+)
+
+type tokenFormatV1 struct {
+	// Intentionally empty.
+}
+
+// GetVersion implements the TokenFormat interface.
+func (*tokenFormatV1) GetVersion() string {
+	return tokenVersionV1
+}
+
+// EncodeSubject implements the TokenFormat interface.
+func (*tokenFormatV1) EncodeSubject(sub int64) interface{} {
+	return strconv.FormatInt(sub, 10)
+}
+
+// DecodeSubject implements the TokenFormat interface.
+func (*tokenFormatV1) DecodeSubject(claim interface{}) (int64, error) {
+	subStr, ok := claim.(string)
+	if !ok {
+		return 0, xerror.New(ErrorInvalidToken, claim)
+	}
+	sub, err := strconv.ParseInt(subStr, 10, 64)
+	if err != nil {
+		return 0, xerror.Wrap(err, ErrorInvalidToken, claim)
+	}
+	return sub, nil
+}
+
+type tokenFormatV2 struct {
+	// Intentionally empty.
+}
+
+// GetVersion implements the TokenFormat interface.
+func (*tokenFormatV2) GetVersion() string {
+	return tokenVersionV2
+}
+
+// EncodeSubject implements the TokenFormat interface.
+func (*tokenFormatV2) EncodeSubject(sub int64) interface{} {
+	return sub
+}
+
+// DecodeSubject implements the TokenFormat interface.
+func (*tokenFormatV2) DecodeSubject(claim interface{}) (int64, error) {
+	subNumber, ok := claim.(json.Number)
+	if !ok {
+		return 0, xerror.New(ErrorInvalidToken, claim)
+	}
+	sub, err := subNumber.Int64()
+	if err != nil {
+		return 0, xerror.Wrap(err, ErrorInvalidToken, claim)
+	}
+	return sub, nil
+}
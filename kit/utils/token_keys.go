@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/ed25519"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+)
+
+const (
+	// ErrorInvalidSignature is returned when an EdDSA signature does not match the signed content.
+	ErrorInvalidSignature = "invalid signature"
+)
+
+// SigningMethodEdDSA implements the EdDSA signing method for Ed25519 keys (RFC 8037).
+var SigningMethodEdDSA jwt.SigningMethod = &signingMethodEdDSA{}
+
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingMethodEdDSA struct{}
+
+// Alg implements the jwt.SigningMethod interface.
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify implements the jwt.SigningMethod interface.
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKey
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return xerror.New(ErrorInvalidSignature)
+	}
+	return nil
+}
+
+// Sign implements the jwt.SigningMethod interface.
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKey
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// tokenKey pairs key material with the only signing method it can be used with.
+type tokenKey struct {
+	method jwt.SigningMethod
+	key    interface{}
+}
+
+// newTokenKey determines the signing method for a RSA, ECDSA, or Ed25519 key.
+func newTokenKey(key interface{}) (*tokenKey, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return &tokenKey{method: jwt.SigningMethodRS256, key: key}, nil
+	case *ecdsa.PrivateKey:
+		return newECDSATokenKey(k.Curve, key)
+	case *ecdsa.PublicKey:
+		return newECDSATokenKey(k.Curve, key)
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return &tokenKey{method: SigningMethodEdDSA, key: key}, nil
+	default:
+		return nil, xerror.New(ErrorInvalidKeyMaterial)
+	}
+}
+
+func newECDSATokenKey(curve elliptic.Curve, key interface{}) (*tokenKey, error) {
+	switch curve {
+	case elliptic.P256():
+		return &tokenKey{method: jwt.SigningMethodES256, key: key}, nil
+	case elliptic.P384():
+		return &tokenKey{method: jwt.SigningMethodES384, key: key}, nil
+	case elliptic.P521():
+		return &tokenKey{method: jwt.SigningMethodES512, key: key}, nil
+	default:
+		return nil, xerror.New(ErrorInvalidKeyMaterial)
+	}
+}
+
+// parseTokenSigningKey parses a PEM encoded RSA (PKCS1 or PKCS8), ECDSA (SEC1 or PKCS8), or Ed25519 (PKCS8) private key.
+func parseTokenSigningKey(privateKeyPEM []byte) (*tokenKey, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, xerror.New(ErrorInvalidKeyMaterial)
+	}
+	if key, err := parseEd25519PrivateKey(block.Bytes); err == nil {
+		return newTokenKey(key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return newTokenKey(key)
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return newTokenKey(key)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidKeyMaterial)
+	}
+	return newTokenKey(key)
+}
+
+// parseTokenVerificationKey parses a PEM encoded RSA, ECDSA, or Ed25519 public key (PKIX) or certificate.
+func parseTokenVerificationKey(publicKeyPEM []byte) (*tokenKey, error) {
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return nil, xerror.New(ErrorInvalidKeyMaterial)
+	}
+	if key, err := parseEd25519PublicKey(block.Bytes); err == nil {
+		return newTokenKey(key)
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return newTokenKey(key)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, xerror.Wrap(err, ErrorInvalidKeyMaterial)
+	}
+	return newTokenKey(cert.PublicKey)
+}
+
+type pkixAlgorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type pkixPublicKey struct {
+	Algorithm pkixAlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+type pkcs8PrivateKey struct {
+	Version    int
+	Algorithm  pkixAlgorithmIdentifier
+	PrivateKey []byte
+}
+
+func parseEd25519PublicKey(der []byte) (ed25519.PublicKey, error) {
+	var pki pkixPublicKey
+	if rest, err := asn1.Unmarshal(der, &pki); err != nil || len(rest) != 0 {
+		return nil, xerror.New(ErrorInvalidKeyMaterial)
+	}
+	if !pki.Algorithm.Algorithm.Equal(oidEd25519) || len(pki.PublicKey.Bytes) != ed25519.PublicKeySize {
+		return nil, xerror.New(ErrorInvalidKeyMaterial)
+	}
+	return ed25519.PublicKey(pki.PublicKey.Bytes), nil
+}
+
+func parseEd25519PrivateKey(der []byte) (ed25519.PrivateKey, error) {
+	var pk pkcs8PrivateKey
+	if rest, err := asn1.Unmarshal(der, &pk); err != nil || len(rest) != 0 {
+		return nil, xerror.New(ErrorInvalidKeyMaterial)
+	}
+	if !pk.Algorithm.Algorithm.Equal(oidEd25519) {
+		return nil, xerror.New(ErrorInvalidKeyMaterial)
+	}
+	var seed []byte
+	if rest, err := asn1.Unmarshal(pk.PrivateKey, &seed); err != nil || len(rest) != 0 || len(seed) != ed25519.SeedSize {
+		return nil, xerror.New(ErrorInvalidKeyMaterial)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
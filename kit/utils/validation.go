@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"fmt"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ErrorInvalidFields is returned when one or more fields fail validation. The error carries the list of FieldError,
+	// which can be extracted with GetFieldErrors.
+	ErrorInvalidFields = "invalid fields: %v"
+)
+
+const (
+	// FieldErrorReasonUTF8 is the FieldError reason for strings that are not valid UTF-8. Failing tags use the tag name
+	// as reason, e.g. TagRegexp.
+	FieldErrorReasonUTF8 = "utf8"
+)
+
+// FieldError describes a field that failed validation. Field is its JSON path, e.g. "items[0].name", and Reason is the
+// failing validation, e.g. "regexp".
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ValidateAllFields validates fields values on the given Value, as ValidateFields, recursing into nested and embedded
+// structs, also through pointers and slices. It returns all the fields that failed validation, or an error if a
+// validation tag is invalid.
+func ValidateAllFields(v reflect.Value) ([]*FieldError, error) {
+	fieldErrors := make([]*FieldError, 0)
+	if err := validateStruct(v, "", &fieldErrors); err != nil {
+		return nil, err
+	}
+	return fieldErrors, nil
+}
+
+// NewInvalidFieldsError creates a new ErrorInvalidFields error carrying the given field errors.
+func NewInvalidFieldsError(fieldErrors []*FieldError) error {
+	fields := make([]string, 0, len(fieldErrors))
+	for _, fieldError := range fieldErrors {
+		fields = append(fields, fieldError.Field)
+	}
+	return xerror.New(ErrorInvalidFields, strings.Join(fields, ", "), fieldErrors)
+}
+
+// GetFieldErrors extracts the field errors carried by an ErrorInvalidFields error, possibly wrapped.
+func GetFieldErrors(err error) ([]*FieldError, bool) {
+	if xerr, ok := err.(interface {
+		DebugObjects() []interface{}
+	}); ok {
+		for _, debugObject := range xerr.DebugObjects() {
+			if fieldErrors, ok := debugObject.([]*FieldError); ok {
+				return fieldErrors, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func validateStruct(v reflect.Value, prefix string, fieldErrors *[]*FieldError) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		// Handled below.
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateStruct(v.Index(i), fmt.Sprintf("%v[%v]", prefix, i), fieldErrors); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue // Unexported.
+		}
+		if f.Anonymous {
+			if err := validateStruct(v.Field(i), prefix, fieldErrors); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.Tag.Get("json") == "-" {
+			continue // Not serialized.
+		}
+
+		name := getJSONFieldName(f)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		reason, err := validateField(f, v.Field(i))
+		if reason != "" {
+			*fieldErrors = append(*fieldErrors, &FieldError{Field: name, Reason: reason})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := maybeGetStringValue(v.Field(i).Interface()); !ok {
+			if err := validateStruct(v.Field(i), name, fieldErrors); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func getJSONFieldName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("json"), ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+// validateField validates the value of a single field. If the value is invalid, it returns the failing validation as
+// reason along with the error; if a tag is invalid, it returns an error only.
+func validateField(f reflect.StructField, fv reflect.Value) (string, error) {
+	s, ok := maybeGetStringValue(fv.Interface())
+	regexpTag := f.Tag.Get(TagRegexp)
+	urlTag := f.Tag.Get(TagURL)
+	enumTag := f.Tag.Get(TagEnum)
+
+	if ok {
+		if s != nil {
+			if !utf8.ValidString(*s) {
+				return FieldErrorReasonUTF8, xerror.New(ErrorInvalidUTF8Encoding, f.Name, *s)
+			}
+			if regexpTag != "" {
+				r, err := regexp.Compile(regexpTag)
+				if err != nil {
+					return "", xerror.Wrap(err, ErrorInvalidRegexpTag, f.Name, regexpTag)
+				}
+				if !r.MatchString(*s) {
+					return TagRegexp, xerror.New(ErrorInvalidFieldValue, *s, f.Name)
+				}
+			}
+			if urlTag != "" {
+				if *s == "" {
+					return TagURL, xerror.New(ErrorInvalidFieldValue, *s, f.Name)
+				}
+				if _, err := url.Parse(*s); err != nil {
+					return TagURL, xerror.Wrap(err, ErrorInvalidFieldValue, *s, f.Name)
+				}
+			}
+			if enumTag != "" {
+				if !checkEnum(*s, strings.Split(enumTag, ",")) {
+					return TagEnum, xerror.New(ErrorInvalidFieldValue, *s, f.Name)
+				}
+			}
+		}
+	} else {
+		if regexpTag != "" {
+			return "", xerror.New(ErrorInvalidFieldTypeForRegexpTag, f.Type, f.Name)
+		}
+		if urlTag != "" {
+			return "", xerror.New(ErrorInvalidFieldTypeForURLTag, f.Type, f.Name)
+		}
+		if enumTag != "" {
+			return "", xerror.New(ErrorInvalidFieldTypeForEnumTag, f.Type, f.Name)
+		}
+	}
+	return "", nil
+}
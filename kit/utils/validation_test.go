@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"github.com/guregu/null"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ibrt/go-xerror.v2/xerror"
+	"reflect"
+	"testing"
+)
+
+type testValidationItem struct {
+	Name string `json:"name" regexp:"^[a-z]+$"`
+}
+
+type testValidationBase struct {
+	Kind string `json:"kind" enum:"a,b"`
+}
+
+type testValidationRequest struct {
+	testValidationBase
+	*testValidationItem
+	Homepage string                `json:"homepage,omitempty" url:"true"`
+	Nickname null.String           `json:"nickname" regexp:"^[a-z]+$"`
+	Item     testValidationItem    `json:"item"`
+	Optional *testValidationItem   `json:"optional"`
+	Items    []*testValidationItem `json:"items"`
+	Ignored  string                `json:"-" enum:"x"`
+	hidden   testValidationItem
+}
+
+func TestValidateAllFields(t *testing.T) {
+	valid := &testValidationRequest{
+		testValidationBase: testValidationBase{Kind: "a"},
+		Homepage:           "http://example.com",
+		Item:               testValidationItem{Name: "item"},
+		Items:              []*testValidationItem{{Name: "one"}, nil},
+		Ignored:            "x",
+		hidden:             testValidationItem{Name: "HIDDEN"},
+	}
+	fieldErrors, err := ValidateAllFields(reflect.ValueOf(valid))
+	assert.Nil(t, err)
+	assert.Empty(t, fieldErrors)
+
+	invalid := &testValidationRequest{
+		testValidationBase: testValidationBase{Kind: "c"},
+		testValidationItem: &testValidationItem{Name: "Embedded"},
+		Nickname:           null.StringFrom("Nick"),
+		Item:               testValidationItem{Name: "\xff"},
+		Optional:           &testValidationItem{Name: "1"},
+		Items:              []*testValidationItem{{Name: "one"}, {Name: "Two"}},
+		Ignored:            "y",
+	}
+	fieldErrors, err = ValidateAllFields(reflect.ValueOf(invalid))
+	assert.Nil(t, err)
+	assert.Equal(t, []*FieldError{
+		{Field: "kind", Reason: TagEnum},
+		{Field: "name", Reason: TagRegexp},
+		{Field: "homepage", Reason: TagURL},
+		{Field: "nickname", Reason: TagRegexp},
+		{Field: "item.name", Reason: FieldErrorReasonUTF8},
+		{Field: "optional.name", Reason: TagRegexp},
+		{Field: "items[1].name", Reason: TagRegexp},
+	}, fieldErrors)
+	assert.Nil(t, ValidateFields(reflect.ValueOf(&testValidationItem{Name: "item"})))
+	assert.Equal(t, "invalid field value 'Item' for field 'Name'", ValidateFields(reflect.ValueOf(&testValidationItem{Name: "Item"})).Error())
+
+	err = xerror.Wrap(NewInvalidFieldsError(fieldErrors[:2]), "bad request")
+	assert.Equal(t, "bad request: invalid fields: kind, name", err.Error())
+	extracted, ok := GetFieldErrors(err)
+	assert.True(t, ok)
+	assert.Equal(t, fieldErrors[:2], extracted)
+	_, ok = GetFieldErrors(xerror.New("other"))
+	assert.False(t, ok)
+
+	type badTagRequest struct {
+		Item struct {
+			Count int `json:"count" enum:"1"`
+		} `json:"item"`
+	}
+	_, err = ValidateAllFields(reflect.ValueOf(&badTagRequest{}))
+	assert.Contains(t, err.Error(), "for enum tag for field 'Count'")
+}